@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/number571/union-bc/kernel"
+)
+
+// Write blocks [begin, end] to archive file.
+// By default all blocks of the chain are exported.
+func exportChain(args []string) int {
+	defer Chain.Close()
+
+	if len(args) < 1 {
+		fmt.Println("usage: export FILE [BEGIN [END]]")
+		return 1
+	}
+
+	var (
+		begin = kernel.Height(0)
+		end   = Chain.Height()
+	)
+
+	if len(args) >= 2 {
+		num, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		begin = kernel.Height(num)
+	}
+
+	if len(args) >= 3 {
+		num, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		end = kernel.Height(num)
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer file.Close()
+
+	buffer := bufio.NewWriter(file)
+	count, err := kernel.ExportBlocks(Chain, kernel.NewArchiveWriter(buffer), begin, end)
+	if err == nil {
+		err = buffer.Flush()
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	fmt.Printf("exported %d blocks [%d, %d] to %s\n", count, begin, end, args[0])
+	return 0
+}
+
+// Accept blocks from archive file. A chain without blocks
+// after genesis takes the genesis block of the archive.
+// State roots of blocks are checked by application.
+func importChain(args []string) int {
+	defer func() { Chain.Close() }()
+
+	if len(args) < 1 {
+		fmt.Println("usage: import FILE")
+		return 1
+	}
+
+	initApplication()
+	defer func() { App.Close() }()
+
+	count, err := importFile(args[0])
+	if err == kernel.ErrGenesisDiffer && Chain.Height() == 0 {
+		genesis, gerr := readGenesis(args[0])
+		if gerr != nil {
+			fmt.Println(gerr)
+			return 1
+		}
+
+		oldChain := Chain
+		App.Close()
+		oldChain.Close()
+		Chain = kernel.NewChain(ChainPath, genesis)
+		oldChain.MoveSubscriptions(Chain)
+		initMempool()
+		initApplication()
+		Log().Warning("IMPORT", 0, genesis.Hash(), Chain.Mempool().Height(), kernel.TXsSize, 0)
+
+		count, err = importFile(args[0])
+	}
+
+	if err != nil {
+		fmt.Printf("imported %d blocks, height=%d: %s\n", count, Chain.Height(), err)
+		return 1
+	}
+
+	fmt.Printf("imported %d blocks, height=%d\n", count, Chain.Height())
+	return 0
+}
+
+func importFile(path string) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	archive := kernel.NewArchiveReader(bufio.NewReader(file))
+	return kernel.ImportBlocks(Chain, archive)
+}
+
+func readGenesis(path string) (kernel.Block, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	height, block, err := kernel.NewArchiveReader(bufio.NewReader(file)).Read()
+	if err != nil {
+		return nil, err
+	}
+
+	if height != 0 {
+		return nil, kernel.ErrGenesisDiffer
+	}
+
+	return block, nil
+}
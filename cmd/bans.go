@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/number571/union-bc/network"
+)
+
+type banRequest struct {
+	Command string `json:"command"`
+	Key     string `json:"key,omitempty"`
+}
+
+type banReply struct {
+	Error string             `json:"error,omitempty"`
+	Bans  []network.BanEntry `json:"bans,omitempty"`
+	Peers []peerScore        `json:"peers,omitempty"`
+}
+
+type peerScore struct {
+	NodeID   string `json:"node_id"`
+	Address  string `json:"address"`
+	Outbound bool   `json:"outbound"`
+	Score    int64  `json:"score"`
+}
+
+// Bans commands over the network protocol, accepted only from admin.
+func handleBans(node network.Node, conn network.Conn, msg network.Message) {
+	var (
+		bans    = node.BanList()
+		request = banRequest{}
+		reply   = banReply{}
+	)
+
+	defer func(conn network.Conn) {
+		data, err := json.Marshal(reply)
+		if err != nil {
+			return
+		}
+		conn.Write(network.NewReply(msg, MsgBans|MaskBit, data))
+	}(conn)
+
+	if !isAdmin(conn) {
+		reply.Error = "permission denied"
+		return
+	}
+
+	err := json.Unmarshal(msg.Body(), &request)
+	if err != nil {
+		reply.Error = "invalid request"
+		return
+	}
+
+	switch request.Command {
+	case "list":
+		reply.Bans = bans.Bans()
+		for _, peer := range node.Connections() {
+			nodeID := peer.Hello().NodeID
+			reply.Peers = append(reply.Peers, peerScore{
+				NodeID:   nodeID,
+				Address:  peer.Address(),
+				Outbound: peer.Outbound(),
+				Score:    bans.Score(nodeID),
+			})
+		}
+	case "lift":
+		if !bans.Unban(request.Key) {
+			reply.Error = "ban not found"
+			return
+		}
+	default:
+		reply.Error = "unknown command"
+	}
+}
+
+// Client of bans commands to running node.
+func bansCommand(args []string) int {
+	const usage = "usage: bans list | lift NODE_ID|IP"
+
+	if len(args) < 1 {
+		fmt.Println(usage)
+		return 1
+	}
+
+	request := banRequest{Command: args[0]}
+	switch request.Command {
+	case "list":
+	case "lift":
+		if len(args) < 2 {
+			fmt.Println(usage)
+			return 1
+		}
+		request.Key = args[1]
+	default:
+		fmt.Println(usage)
+		return 1
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	conn := network.NewConn(NodeKey, Address)
+	if conn == nil {
+		fmt.Println("node is not available")
+		return 1
+	}
+	defer conn.Close()
+
+	msg, err := conn.Request(network.NewMessage(MsgBans, data))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	reply := banReply{}
+	if err := json.Unmarshal(msg.Body(), &reply); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	out, err := json.MarshalIndent(reply, "", "\t")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if reply.Error != "" {
+		return 1
+	}
+	return 0
+}
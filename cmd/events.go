@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/number571/union-bc/kernel"
+	"github.com/number571/union-bc/network"
+)
+
+// Stream chain events to the connection until it is closed.
+func handleSubscribe(node network.Node, conn network.Conn, msg network.Message) {
+	sub := Chain.Subscribe(EventsSize)
+
+	go func() {
+		defer sub.Close()
+
+		for {
+			select {
+			case <-conn.Done():
+				return
+			case event, ok := <-sub.Events():
+				if !ok {
+					return
+				}
+
+				data, err := json.Marshal(event)
+				if err != nil {
+					continue
+				}
+
+				conn.Write(network.NewMessage(MsgEvent, data))
+			}
+		}
+	}()
+}
+
+type eventLine struct {
+	Kind   string        `json:"kind"`
+	Height kernel.Height `json:"height"`
+	Hash   string        `json:"hash"`
+}
+
+// Print events of running node as JSON lines.
+func eventsCommand() int {
+	conn := network.NewConn(NodeKey, Address)
+	if conn == nil {
+		fmt.Println("node is not available")
+		return 1
+	}
+	defer conn.Close()
+
+	conn.Write(network.NewMessage(MsgSubscribe, nil))
+
+	for {
+		msg, err := conn.Read()
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+
+		if msg.Head() != MsgEvent {
+			continue
+		}
+
+		event := kernel.Event{}
+		if err := json.Unmarshal(msg.Body(), &event); err != nil {
+			continue
+		}
+
+		line, err := json.Marshal(eventLine{
+			Kind:   event.Kind,
+			Height: event.Height,
+			Hash:   fmt.Sprintf("%X", event.Hash),
+		})
+		if err != nil {
+			continue
+		}
+		fmt.Println(string(line))
+	}
+}
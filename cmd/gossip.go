@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/number571/union-bc/kernel"
+	"github.com/number571/union-bc/network"
+)
+
+var (
+	Inventory = &inventory{}
+)
+
+// Hashes of mempool transactions waiting for announcement.
+type inventory struct {
+	mtx    sync.Mutex
+	hashes [][]byte
+}
+
+func (inv *inventory) Announce(hash kernel.Hash) {
+	inv.mtx.Lock()
+	defer inv.mtx.Unlock()
+
+	if len(inv.hashes) >= InvSize*InvSize {
+		return
+	}
+
+	inv.hashes = append(inv.hashes, hash)
+}
+
+func (inv *inventory) take() [][]byte {
+	inv.mtx.Lock()
+	defer inv.mtx.Unlock()
+
+	n := len(inv.hashes)
+	if n > InvSize {
+		n = InvSize
+	}
+
+	hashes := inv.hashes[:n]
+	inv.hashes = inv.hashes[n:]
+	return hashes
+}
+
+// Broadcast announcements of new mempool transactions.
+func runInventory(node network.Node) {
+	for {
+		time.Sleep(InvInterval * time.Millisecond)
+
+		for {
+			hashes := Inventory.take()
+			if len(hashes) == 0 {
+				break
+			}
+
+			data, err := json.Marshal(hashes)
+			if err != nil {
+				break
+			}
+
+			msg := network.NewMessage(MsgInvTX, data)
+			for _, conn := range node.Connections() {
+				if hasCapability(conn, CapRelay) {
+					conn.Send(msg)
+				}
+			}
+		}
+	}
+}
+
+// Request announced transactions which are not known.
+func handleInvTX(node network.Node, conn network.Conn, msg network.Message) {
+	var (
+		mempool = Chain.Mempool()
+		hashes  [][]byte
+		unknown [][]byte
+	)
+
+	err := json.Unmarshal(msg.Body(), &hashes)
+	if err != nil || len(hashes) > InvSize {
+		node.Misbehave(conn, PenaltyMalformed, "malformed inventory")
+		return
+	}
+
+	for _, hash := range hashes {
+		if mempool.TX(hash) != nil {
+			continue
+		}
+		if Chain.TX(hash) != nil {
+			continue
+		}
+		unknown = append(unknown, hash)
+	}
+
+	if len(unknown) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(unknown)
+	if err != nil {
+		return
+	}
+
+	conn.Write(network.NewMessage(MsgGetInvTX, data))
+}
+
+// Send requested mempool transactions.
+func handleGetInvTX(node network.Node, conn network.Conn, msg network.Message) {
+	var (
+		mempool = Chain.Mempool()
+		hashes  [][]byte
+		txs     [][]byte
+	)
+
+	err := json.Unmarshal(msg.Body(), &hashes)
+	if err != nil || len(hashes) > InvSize {
+		node.Misbehave(conn, PenaltyMalformed, "malformed inventory request")
+		return
+	}
+
+	for _, hash := range hashes {
+		tx := mempool.TX(hash)
+		if tx == nil {
+			continue
+		}
+		txs = append(txs, tx.Bytes())
+	}
+
+	if len(txs) == 0 {
+		return
+	}
+
+	data, err := json.Marshal(txs)
+	if err != nil {
+		return
+	}
+
+	conn.Write(network.NewMessage(MsgPushTXs, data))
+}
+
+// Accept relayed transactions and announce them further.
+func handlePushTXs(node network.Node, conn network.Conn, msg network.Message) {
+	var (
+		mempool = Chain.Mempool()
+		txs     [][]byte
+	)
+
+	err := json.Unmarshal(msg.Body(), &txs)
+	if err != nil || len(txs) > InvSize {
+		node.Misbehave(conn, PenaltyMalformed, "malformed relayed txs")
+		return
+	}
+
+	for _, txBytes := range txs {
+		tx := kernel.LoadTransaction(txBytes)
+		if tx == nil || Schemas.Check(tx) != nil {
+			node.Misbehave(conn, PenaltyTX, "invalid relayed tx")
+			continue
+		}
+
+		if Chain.TX(tx.Hash()) != nil {
+			continue
+		}
+
+		if mempool.Push(tx) == nil {
+			Inventory.Announce(tx.Hash())
+		}
+	}
+}
+
+func hasCapability(conn network.Conn, capability string) bool {
+	for _, c := range conn.Hello().Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/number571/go-peer/crypto"
+	"github.com/number571/go-peer/encoding"
+	"github.com/number571/union-bc/kernel"
+	"github.com/number571/union-bc/kvstore"
+	"github.com/number571/union-bc/network"
+)
+
+type kvReply struct {
+	Error string         `json:"error,omitempty"`
+	Proof *kvstore.Proof `json:"proof,omitempty"`
+}
+
+type kvSetResult struct {
+	Hash string `json:"hash"`
+	Code uint64 `json:"code"`
+}
+
+type kvGetResult struct {
+	Error    string        `json:"error,omitempty"`
+	Key      string        `json:"key,omitempty"`
+	Value    string        `json:"value"`
+	Height   kernel.Height `json:"height,omitempty"`
+	Root     string        `json:"root,omitempty"`
+	Verified bool          `json:"verified"`
+}
+
+// Schemas of transaction types known to the node.
+func newSchemas() kernel.Registry {
+	schemas := kernel.NewRegistry()
+	if err := schemas.Register(kvstore.TXTypeKV, kvstore.NewSchema()); err != nil {
+		panic(err)
+	}
+	return schemas
+}
+
+// State of application is stored in the chain directory
+// and follows the chain from its last committed height.
+func initApplication() {
+	App = kvstore.NewKVStore(filepath.Join(ChainPath, kvstore.DBPath))
+	if App == nil {
+		panic("kvstore is nil")
+	}
+	Chain.SetApplication(App)
+}
+
+// Value of key with proof against state root of block at height.
+func handleQueryKV(node network.Node, conn network.Conn, msg network.Message) {
+	reply := kvReply{}
+
+	defer func(conn network.Conn) {
+		data, err := json.Marshal(reply)
+		if err != nil {
+			return
+		}
+		conn.Write(network.NewReply(msg, MsgQueryKV|MaskBit, data))
+	}(conn)
+
+	proof, ok := App.Query(msg.Body())
+	if !ok {
+		reply.Error = "key not found"
+		return
+	}
+
+	reply.Proof = &proof
+}
+
+// Client of key-value application of running node.
+func kvCommand(args []string) int {
+	const usage = "usage: kv get KEY | set KEY VALUE | delete KEY"
+
+	if len(args) < 2 {
+		fmt.Println(usage)
+		return 1
+	}
+
+	var payLoad []byte
+	switch args[0] {
+	case "get":
+		return kvGet([]byte(args[1]))
+	case "set":
+		if len(args) < 3 {
+			fmt.Println(usage)
+			return 1
+		}
+		payLoad = kvstore.NewSet([]byte(args[1]), []byte(args[2]))
+	case "delete":
+		payLoad = kvstore.NewDelete([]byte(args[1]))
+	default:
+		fmt.Println(usage)
+		return 1
+	}
+
+	tx := kernel.NewTypedTransaction(crypto.NewPrivKey(kernel.KeySize), kvstore.TXTypeKV, payLoad, 0)
+	if tx == nil {
+		fmt.Println("invalid transaction")
+		return 1
+	}
+
+	conn := network.NewConn(NodeKey, Address)
+	if conn == nil {
+		fmt.Println("node is not available")
+		return 1
+	}
+	defer conn.Close()
+
+	msg, err := conn.Request(network.NewMessage(MsgSetTX, tx.Bytes()))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	result := kvSetResult{
+		Hash: fmt.Sprintf("%X", tx.Hash()),
+		Code: encoding.BytesToUint64(msg.Body()),
+	}
+
+	out, err := json.MarshalIndent(result, "", "\t")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if result.Code != RetOK {
+		return 1
+	}
+	return 0
+}
+
+func kvGet(key []byte) int {
+	conn := network.NewConn(NodeKey, Address)
+	if conn == nil {
+		fmt.Println("node is not available")
+		return 1
+	}
+	defer conn.Close()
+
+	msg, err := conn.Request(network.NewMessage(MsgQueryKV, key))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	reply := kvReply{}
+	if err := json.Unmarshal(msg.Body(), &reply); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	result := kvGetResult{Error: reply.Error}
+	if reply.Proof != nil {
+		var root kernel.Hash
+		if block := proofBlock(conn, reply.Proof.Height); block != nil {
+			root = block.StateRoot()
+		}
+
+		result.Key = string(reply.Proof.Key)
+		result.Value = string(reply.Proof.Value)
+		result.Height = reply.Proof.Height
+		result.Root = fmt.Sprintf("%X", root)
+		result.Verified = root != nil && kvstore.VerifyProof(root, *reply.Proof)
+	}
+
+	out, err := json.MarshalIndent(result, "", "\t")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if !result.Verified {
+		return 1
+	}
+	return 0
+}
+
+// Block at height from other node if one is available,
+// so root of proof is not given by the node of proof.
+func proofBlock(conn network.Conn, height kernel.Height) kernel.Block {
+	for _, addr := range seedList() {
+		peer := network.NewConn(NodeKey, addr)
+		if peer == nil {
+			continue
+		}
+		block := getBlock(peer, height)
+		peer.Close()
+		if block != nil {
+			return block
+		}
+	}
+	return getBlock(conn, height)
+}
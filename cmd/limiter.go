@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/number571/union-bc/kernel"
+	"github.com/number571/union-bc/network"
+)
+
+// Token bucket per key: rate tokens in second up to burst.
+type rateLimiter struct {
+	mtx     sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Transactions submitted by connection and still in mempool.
+type pendingTracker struct {
+	mtx     sync.Mutex
+	pending map[string]map[string]kernel.Hash
+}
+
+// Keys of open connections in limiters. Entries of key
+// are removed from limiters when connection is closed.
+type connKeys struct {
+	mtx   sync.Mutex
+	conns map[string]network.Conn
+}
+
+func newConnKeys() *connKeys {
+	return &connKeys{
+		conns: make(map[string]network.Conn),
+	}
+}
+
+// Identity and remote address of connection.
+func (keys *connKeys) Key(conn network.Conn) string {
+	key := fmt.Sprintf("conn:%s:%s", conn.PubKey().Address(), conn.Address())
+
+	keys.mtx.Lock()
+	defer keys.mtx.Unlock()
+
+	if keys.conns[key] == conn {
+		return key
+	}
+	keys.conns[key] = conn
+
+	go func() {
+		<-conn.Done()
+
+		keys.mtx.Lock()
+		defer keys.mtx.Unlock()
+
+		if keys.conns[key] != conn {
+			return
+		}
+		delete(keys.conns, key)
+		ConnLimiter.Remove(key)
+		ConnPending.Remove(key)
+	}()
+
+	return key
+}
+
+func newRateLimiter(rate, burst float64) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (limiter *rateLimiter) Allow(key string) bool {
+	limiter.mtx.Lock()
+	defer limiter.mtx.Unlock()
+
+	now := time.Now()
+
+	if len(limiter.buckets) > LimiterSize {
+		limiter.cleanup(now)
+	}
+
+	b, ok := limiter.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limiter.burst, last: now}
+		limiter.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * limiter.rate
+	if b.tokens > limiter.burst {
+		b.tokens = limiter.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+func (limiter *rateLimiter) Remove(key string) {
+	limiter.mtx.Lock()
+	defer limiter.mtx.Unlock()
+
+	delete(limiter.buckets, key)
+}
+
+// Remove buckets which are refilled, they are equal to new ones.
+func (limiter *rateLimiter) cleanup(now time.Time) {
+	for key, b := range limiter.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*limiter.rate >= limiter.burst {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+func newPendingTracker() *pendingTracker {
+	return &pendingTracker{
+		pending: make(map[string]map[string]kernel.Hash),
+	}
+}
+
+// Number of transactions of the key which are still in mempool.
+func (tracker *pendingTracker) Count(key string, mempool kernel.Mempool) uint64 {
+	tracker.mtx.Lock()
+	defer tracker.mtx.Unlock()
+
+	if len(tracker.pending) > LimiterSize {
+		for k := range tracker.pending {
+			tracker.prune(k, mempool)
+		}
+	}
+
+	return tracker.prune(key, mempool)
+}
+
+func (tracker *pendingTracker) Add(key string, hash kernel.Hash) {
+	tracker.mtx.Lock()
+	defer tracker.mtx.Unlock()
+
+	hashes, ok := tracker.pending[key]
+	if !ok {
+		hashes = make(map[string]kernel.Hash)
+		tracker.pending[key] = hashes
+	}
+
+	hashes[string(hash)] = hash
+}
+
+func (tracker *pendingTracker) Remove(key string) {
+	tracker.mtx.Lock()
+	defer tracker.mtx.Unlock()
+
+	delete(tracker.pending, key)
+}
+
+func (tracker *pendingTracker) prune(key string, mempool kernel.Mempool) uint64 {
+	hashes := tracker.pending[key]
+	for k, hash := range hashes {
+		if mempool.TX(hash) == nil {
+			delete(hashes, k)
+		}
+	}
+
+	if len(hashes) == 0 {
+		delete(tracker.pending, key)
+		return 0
+	}
+
+	return uint64(len(hashes))
+}
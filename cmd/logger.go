@@ -30,6 +30,12 @@ func (lg *Logger) Error(name string, height kernel.Height, mempool kernel.Height
 		'E', name, height, []byte{0}, []byte{0}, mempool, txs, conns)
 }
 
+func (lg *Logger) TX(name string, tx kernel.Transaction, payload string) {
+	colorYellow := "\033[33m"
+	log.Printf(colorYellow+"[%c] %-10shash=%016X...%016X type=%d payload=%s"+lg.reset,
+		'T', name, tx.Hash()[:8], tx.Hash()[24:], tx.Type(), payload)
+}
+
 func (lg *Logger) Info(name string, height kernel.Height, hash []byte, mempool kernel.Height, txs int, conns int) {
 	log.Printf(lg.message+lg.reset,
 		'I', name, height, hash[:8], hash[24:], mempool, txs, conns)
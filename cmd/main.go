@@ -2,24 +2,50 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
 	"sort"
 	"strconv"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/number571/go-peer/crypto"
 	"github.com/number571/go-peer/encoding"
 	"github.com/number571/union-bc/kernel"
+	"github.com/number571/union-bc/kvstore"
 	"github.com/number571/union-bc/network"
 )
 
 var (
 	Chain       kernel.Chain
+	App         kvstore.KVStore
+	Schemas     = newSchemas()
 	CurrentTime uint64
 	ChainPath   = "chain" + os.Args[1]
+	KeyPath     = "node" + os.Args[1] + ".key"
+	NodeKey     = loadNodeKey(KeyPath)
+	PeersPath   = "peers" + os.Args[1] + ".json"
+	Peers       = network.NewAddrBook(PeersPath)
+)
+
+var (
+	ConnLimiter   = newRateLimiter(ConnRate, ConnBurst)
+	SenderLimiter = newRateLimiter(SenderRate, SenderBurst)
+	ConnPending   = newPendingTracker()
+	ConnKeys      = newConnKeys()
+	AddrRequests  = newAddrRequests()
+)
+
+// Block producer and clients, stopped by shutdown
+// of node and waited before the chain is closed.
+var (
+	Workers sync.WaitGroup
 )
 
 var (
@@ -36,42 +62,171 @@ type updateBlock struct {
 	Block  []byte        `json:"block"`
 }
 
+type txInfo struct {
+	TX            []byte        `json:"tx"`
+	Type          kernel.TXType `json:"type"`
+	Payload       string        `json:"payload"`
+	Height        kernel.Height `json:"height"`
+	Position      uint64        `json:"position"`
+	Confirmations uint64        `json:"confirmations"`
+}
+
+type typeQuery struct {
+	Type   kernel.TXType `json:"type"`
+	Offset uint64        `json:"offset"`
+	Limit  uint64        `json:"limit"`
+}
+
+type signerQuery struct {
+	Validator []byte `json:"validator"`
+	Offset    uint64 `json:"offset"`
+	Limit     uint64 `json:"limit"`
+}
+
 func init() {
+	// Commands to running node, chain is locked by it.
+	if len(os.Args) >= 3 {
+		switch os.Args[2] {
+		case "mempool":
+			os.Exit(mempoolCommand(os.Args[3:]))
+		case "events":
+			os.Exit(eventsCommand())
+		case "kv":
+			os.Exit(kvCommand(os.Args[3:]))
+		case "bans":
+			os.Exit(bansCommand(os.Args[3:]))
+		}
+	}
+
 	if pathIsExist(ChainPath) {
 		Chain = kernel.LoadChain(ChainPath)
 	} else {
 		Chain = kernel.NewChain(ChainPath, newGenesis())
 	}
+	initMempool()
+
+	// Commands on chain files work without application, so damaged
+	// chain can be inspected. Application follows the chain on start.
+	if len(os.Args) < 3 {
+		initApplication()
+		return
+	}
 
-	if len(os.Args) >= 3 && os.Args[2] == "rollback" {
+	switch os.Args[2] {
+	case "rollback":
 		defaultNum := 10
 		if len(os.Args) == 4 {
 			defaultNum, _ = strconv.Atoi(os.Args[3])
 		}
 		Chain.Rollback(uint64(defaultNum))
 		os.Exit(1)
+	case "verify":
+		repair := len(os.Args) == 4 && os.Args[3] == "repair"
+		os.Exit(verifyChain(repair))
+	case "export":
+		os.Exit(exportChain(os.Args[3:]))
+	case "import":
+		os.Exit(importChain(os.Args[3:]))
 	}
+
+	initApplication()
 }
 
 func main() {
-	node := network.NewNode().
+	node := network.NewNode(NodeKey).
+		SetHello(localHello).
+		SetAddrBook(Peers).
+		Subscribe(MsgSetBlock).
 		Handle(MsgGetTime, handleGetTime).
 		Handle(MsgGetHeight, handleGetHeight).
 		Handle(MsgGetBlock, handleGetBlock).
 		Handle(MsgSetBlock, handleSetBlock).
 		Handle(MsgGetTX, handleGetTX).
-		Handle(MsgSetTX, handleSetTX)
+		Handle(MsgSetTX, handleSetTX).
+		Handle(MsgGetSignerTXs, handleGetSignerTXs).
+		Handle(MsgGetBlockByHash, handleGetBlockByHash).
+		Handle(MsgGetMetrics, handleGetMetrics).
+		Handle(MsgInvTX, handleInvTX).
+		Handle(MsgGetInvTX, handleGetInvTX).
+		Handle(MsgPushTXs, handlePushTXs).
+		Handle(MsgMempool, handleMempool).
+		Handle(MsgSubscribe, handleSubscribe).
+		Handle(MsgQueryKV, handleQueryKV).
+		Handle(MsgGetTypeTXs, handleGetTypeTXs).
+		Handle(MsgGetAddr, handleGetAddr).
+		Handle(MsgAddr, handleAddr).
+		Handle(MsgBans, handleBans)
+	initNetwork(node)
+
+	go waitShutdown(node)
 
 	initNode(node)
-	initClient()
+	initClient(node)
 }
 
-func initClient() {
+// Stop node on interrupt, running handlers and workers
+// finish before the chain is closed.
+func waitShutdown(node network.Node) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTime*time.Second)
+	defer cancel()
+
+	if err := node.Shutdown(ctx); err != nil {
+		fmt.Println(err)
+	}
+
+	Workers.Wait()
+	Peers.Save()
+
+	node.Mutex().Lock()
+	App.Close()
+	Chain.Close()
+	os.Exit(0)
+}
+
+// Gossip fanout and policy of full send queue are set by environment
+// variables, network defaults are kept when they are not set.
+func initNetwork(node network.Node) {
+	if env := os.Getenv(FanoutEnv); env != "" {
+		fanout, err := strconv.Atoi(env)
+		if err != nil || fanout < 0 {
+			panic("gossip fanout is invalid")
+		}
+		node.SetFanout(fanout)
+	}
+
+	switch os.Getenv(QueueEnv) {
+	case "":
+	case "drop":
+		node.SetQueuePolicy(network.QueueDrop)
+	case "disconnect":
+		node.SetQueuePolicy(network.QueueDisconnect)
+	default:
+		panic("queue policy is invalid")
+	}
+}
+
+func localHello() network.Hello {
+	return network.Hello{
+		ChainID:      Chain.Block(0).Hash(),
+		Height:       uint64(Chain.Height()),
+		Address:      Address,
+		Capabilities: Capabilities,
+	}
+}
+
+func initClient(node network.Node) {
 	time.Sleep(1 * time.Second)
 
 	for i := 0; i < ClientsNum; i++ {
+		Workers.Add(1)
 		go func() {
-			conn := network.NewConn(Address)
+			defer Workers.Done()
+
+			conn := network.NewConn(NodeKey, Address)
 			if conn == nil {
 				panic("conn is nil")
 			}
@@ -80,10 +235,16 @@ func initClient() {
 			for {
 				priv := crypto.NewPrivKey(kernel.KeySize)
 				for i := 0; i < TXsInSecond; i++ {
-					tx := kernel.NewTransaction(priv, []byte(crypto.RandString(20)))
-					_ = conn.Request(network.NewMessage(MsgSetTX, tx.Bytes()))
+					payLoad := kvstore.NewSet([]byte(crypto.RandString(8)), []byte(crypto.RandString(20)))
+					tx := kernel.NewTypedTransaction(priv, kvstore.TXTypeKV, payLoad, 0)
+					_, _ = conn.Request(network.NewMessage(MsgSetTX, tx.Bytes()))
+				}
+
+				select {
+				case <-node.Done():
+					return
+				case <-time.After(1 * time.Second):
 				}
-				time.Sleep(1 * time.Second)
 			}
 		}()
 	}
@@ -95,15 +256,26 @@ func initNode(node network.Node) {
 	fmt.Println("Node is listening...")
 	var conn network.Conn
 
-	for _, addr := range ListAddr {
-		if addr == Address {
+	// Sync with the highest node of the same chain,
+	// node without blocks takes genesis of the peer.
+	for _, addr := range bootstrapList() {
+		peer := network.NewConn(NodeKey, addr)
+		if peer == nil {
 			continue
 		}
-		conn = network.NewConn(addr)
-		if conn == nil {
+		sameChain := bytes.Equal(peer.Hello().ChainID, Chain.Block(0).Hash())
+		if !sameChain && Chain.Height() != 0 {
+			peer.Close()
 			continue
 		}
-		break
+		if conn != nil && conn.Hello().Height >= peer.Hello().Height {
+			peer.Close()
+			continue
+		}
+		if conn != nil {
+			conn.Close()
+		}
+		conn = peer
 	}
 
 	if conn != nil {
@@ -113,20 +285,33 @@ func initNode(node network.Node) {
 	}
 
 	// Connects
-	for _, addr := range ListAddr {
-		if addr == Address {
-			continue
-		}
-		node.Connect(addr)
+	manager := network.NewPeerManager(node, Peers, PeersNum).
+		OnConnect(requestAddr)
+	for _, addr := range seedList() {
+		manager.AddPersistent(addr)
 	}
+	go manager.Run(context.Background())
 
 	// Listen port
 	go node.Listen(Address)
 
+	// Relay mempool
+	go runInventory(node)
+
+	// Discover peers
+	go runDiscovery(node)
+
 	// Generate block
+	Workers.Add(1)
 	go func(node network.Node) {
+		defer Workers.Done()
+
 		for {
-			time.Sleep(1 * time.Second)
+			select {
+			case <-node.Done():
+				return
+			case <-time.After(1 * time.Second):
+			}
 			atomic.AddUint64(&CurrentTime, 1)
 
 			ctime := atomic.LoadUint64(&CurrentTime) % IntervalTime
@@ -157,18 +342,8 @@ func commitBlock(node network.Node, mempool kernel.Mempool, height kernel.Height
 		block: commitBlock,
 	}
 
-	for _, addr := range ListAddr {
-		if addr == Address {
-			continue
-		}
-
-		conn := network.NewConn(addr)
-		if conn == nil {
-			continue
-		}
-
+	for _, conn := range node.Connections() {
 		block := getBlock(conn, height)
-		conn.Close()
 		if block == nil {
 			continue
 		}
@@ -242,8 +417,8 @@ func getBlock(conn network.Conn, height kernel.Height) kernel.Block {
 		encoding.Uint64ToBytes(uint64(height)),
 	)
 
-	msg = conn.Request(msg)
-	if msg == nil {
+	msg, err := conn.Request(msg)
+	if err != nil {
 		return nil
 	}
 
@@ -256,8 +431,8 @@ func getTime(conn network.Conn) uint64 {
 		nil,
 	)
 
-	msg = conn.Request(msg)
-	if msg == nil {
+	msg, err := conn.Request(msg)
+	if err != nil {
 		return 0
 	}
 
@@ -284,8 +459,13 @@ func syncBlocks(conn network.Conn) {
 		}
 
 		if i == 0 {
-			Chain.Close()
+			oldChain := Chain
+			App.Close()
+			oldChain.Close()
 			Chain = kernel.NewChain(ChainPath, block)
+			oldChain.MoveSubscriptions(Chain)
+			initMempool()
+			initApplication()
 			mempool = Chain.Mempool()
 			Log().Warning("SYNCABLE", i, block.Hash(), mempool.Height(), kernel.TXsSize, 0)
 		}
@@ -306,7 +486,8 @@ func handleGetTime(node network.Node, conn network.Conn, msg network.Message) {
 		currTime = atomic.LoadUint64(&CurrentTime)
 	)
 
-	rmsg := network.NewMessage(
+	rmsg := network.NewReply(
+		msg,
 		MsgGetTime|MaskBit,
 		encoding.Uint64ToBytes(currTime),
 	)
@@ -319,7 +500,8 @@ func handleGetHeight(node network.Node, conn network.Conn, msg network.Message)
 		height = uint64(Chain.Height())
 	)
 
-	rmsg := network.NewMessage(
+	rmsg := network.NewReply(
+		msg,
 		MsgGetHeight|MaskBit,
 		encoding.Uint64ToBytes(height),
 	)
@@ -338,7 +520,8 @@ func handleGetBlock(node network.Node, conn network.Conn, msg network.Message) {
 		blockBytes = block.Bytes()
 	}
 
-	rmsg := network.NewMessage(
+	rmsg := network.NewReply(
+		msg,
 		MsgGetBlock|MaskBit,
 		blockBytes,
 	)
@@ -346,6 +529,34 @@ func handleGetBlock(node network.Node, conn network.Conn, msg network.Message) {
 	conn.Write(rmsg)
 }
 
+func handleGetBlockByHash(node network.Node, conn network.Conn, msg network.Message) {
+	var (
+		hash       = kernel.Hash(msg.Body())
+		blockBytes = []byte{}
+	)
+
+	height, ok := Chain.BlockHeight(hash)
+	if block := Chain.Block(height); ok && block != nil {
+		upBlock := updateBlock{
+			Height: height,
+			Block:  block.Bytes(),
+		}
+
+		data, err := json.Marshal(upBlock)
+		if err == nil {
+			blockBytes = data
+		}
+	}
+
+	rmsg := network.NewReply(
+		msg,
+		MsgGetBlockByHash|MaskBit,
+		blockBytes,
+	)
+
+	conn.Write(rmsg)
+}
+
 func handleSetBlock(node network.Node, conn network.Conn, msg network.Message) {
 	var (
 		mempool   = Chain.Mempool()
@@ -356,11 +567,13 @@ func handleSetBlock(node network.Node, conn network.Conn, msg network.Message) {
 	upBlock := updateBlock{}
 	err := json.Unmarshal(msg.Body(), &upBlock)
 	if err != nil {
+		node.Misbehave(conn, PenaltyMalformed, "malformed block message")
 		return
 	}
 
 	newBlock := kernel.LoadBlock(upBlock.Block)
 	if newBlock == nil {
+		node.Misbehave(conn, PenaltyBlock, "invalid block")
 		return
 	}
 
@@ -376,7 +589,9 @@ func handleSetBlock(node network.Node, conn network.Conn, msg network.Message) {
 		return
 	}
 
+	// Same block is relayed, peers out of fanout of sender get it.
 	if bytes.Equal(newBlock.Hash(), currBlock.Hash()) {
+		node.Broadcast(msg)
 		return
 	}
 
@@ -404,15 +619,35 @@ func handleSetBlock(node network.Node, conn network.Conn, msg network.Message) {
 func handleGetTX(node network.Node, conn network.Conn, msg network.Message) {
 	var (
 		hash    = kernel.Hash(msg.Body())
+		height  = Chain.Height()
 		tx      = Chain.TX(hash)
 		txBytes = []byte{}
 	)
 
+	// Transaction without location is returned
+	// with zero confirmations.
 	if tx != nil {
-		txBytes = tx.Bytes()
+		info := txInfo{
+			TX:      tx.Bytes(),
+			Type:    tx.Type(),
+			Payload: Schemas.Render(tx),
+		}
+
+		loc, ok := Chain.TXLocation(hash)
+		if ok && loc.Height <= height {
+			info.Height = loc.Height
+			info.Position = loc.Position
+			info.Confirmations = uint64(height-loc.Height) + 1
+		}
+
+		data, err := json.Marshal(info)
+		if err == nil {
+			txBytes = data
+		}
 	}
 
-	rmsg := network.NewMessage(
+	rmsg := network.NewReply(
+		msg,
 		MsgGetTX|MaskBit,
 		txBytes,
 	)
@@ -420,40 +655,153 @@ func handleGetTX(node network.Node, conn network.Conn, msg network.Message) {
 	conn.Write(rmsg)
 }
 
+func handleGetSignerTXs(node network.Node, conn network.Conn, msg network.Message) {
+	var (
+		query   = signerQuery{}
+		entries = []kernel.TXEntry{}
+	)
+
+	err := json.Unmarshal(msg.Body(), &query)
+	if err == nil {
+		pub := kernel.LoadPubKey(query.Validator)
+		if pub != nil {
+			entries = append(entries, Chain.TXsBySigner(pub, query.Offset, query.Limit)...)
+		}
+	}
+
+	entriesBytes, err := json.Marshal(entries)
+	if err != nil {
+		return
+	}
+
+	rmsg := network.NewReply(
+		msg,
+		MsgGetSignerTXs|MaskBit,
+		entriesBytes,
+	)
+
+	conn.Write(rmsg)
+}
+
+func handleGetTypeTXs(node network.Node, conn network.Conn, msg network.Message) {
+	var (
+		query   = typeQuery{}
+		entries = []kernel.TXEntry{}
+	)
+
+	err := json.Unmarshal(msg.Body(), &query)
+	if err == nil {
+		entries = append(entries, Chain.TXsByType(query.Type, query.Offset, query.Limit)...)
+	}
+
+	entriesBytes, err := json.Marshal(entries)
+	if err != nil {
+		return
+	}
+
+	rmsg := network.NewReply(
+		msg,
+		MsgGetTypeTXs|MaskBit,
+		entriesBytes,
+	)
+
+	conn.Write(rmsg)
+}
+
 func handleSetTX(node network.Node, conn network.Conn, msg network.Message) {
 	var (
 		mempool = Chain.Mempool()
 		tx      = kernel.LoadTransaction(msg.Body())
-		hash    = tx.Hash()
-		retCode = uint64(0)
+		connKey = ConnKeys.Key(conn)
+		retCode = uint64(RetOK)
 	)
 
 	defer func(conn network.Conn) {
-		msg := network.NewMessage(
+		rmsg := network.NewReply(
+			msg,
 			MsgSetTX|MaskBit,
 			encoding.Uint64ToBytes(retCode),
 		)
-		conn.Write(msg)
+		conn.Write(rmsg)
 	}(conn)
 
+	if !ConnLimiter.Allow(connKey) {
+		retCode = RetRateLimited
+		Metrics.Inc(&Metrics.RateLimited)
+		return
+	}
+
 	if tx == nil {
-		retCode = 2
+		retCode = RetTXInvalid
+		Metrics.Inc(&Metrics.TXsInvalid)
+		node.Misbehave(conn, PenaltyTX, "invalid tx")
 		return
 	}
 
-	txInChain := Chain.TX(hash)
+	if err := Schemas.Check(tx); err != nil {
+		retCode = RetTXMalformed
+		Metrics.Inc(&Metrics.TXsMalformed)
+		Log().TX("MALFORMED", tx, Schemas.Render(tx))
+		node.Misbehave(conn, PenaltyTX, "malformed tx")
+		return
+	}
+
+	if !SenderLimiter.Allow(tx.Validator().Address()) {
+		retCode = RetRateLimited
+		Metrics.Inc(&Metrics.RateLimited)
+		return
+	}
+
+	txInChain := Chain.TX(tx.Hash())
 	if txInChain != nil {
-		retCode = 3
+		retCode = RetTXInChain
+		Metrics.Inc(&Metrics.TXsInChain)
 		return
 	}
 
-	txInMempool := mempool.TX(hash)
-	if txInMempool != nil {
-		retCode = 4
+	if ConnPending.Count(connKey, mempool) >= ConnPendingSize {
+		retCode = RetConnQuota
+		Metrics.Inc(&Metrics.ConnQuota)
 		return
 	}
 
-	mempool.Push(tx)
+	switch mempool.Push(tx) {
+	case nil:
+		retCode = RetOK
+		ConnPending.Add(connKey, tx.Hash())
+		Inventory.Announce(tx.Hash())
+		Metrics.Inc(&Metrics.TXsAccepted)
+	case kernel.ErrTXExists:
+		retCode = RetTXInMempool
+		Metrics.Inc(&Metrics.TXsInMempool)
+	case kernel.ErrMempoolFull:
+		retCode = RetMempoolFull
+		Metrics.Inc(&Metrics.MempoolFull)
+	case kernel.ErrSenderQuota:
+		retCode = RetSenderQuota
+		Metrics.Inc(&Metrics.SenderQuota)
+	case kernel.ErrTXRejected:
+		retCode = RetTXRejected
+		Metrics.Inc(&Metrics.TXsRejected)
+	default:
+		retCode = RetTXInvalid
+		Metrics.Inc(&Metrics.TXsInvalid)
+	}
+}
+
+func handleGetMetrics(node network.Node, conn network.Conn, msg network.Message) {
+	data, err := json.Marshal(Metrics.Snapshot(node))
+	if err != nil {
+		return
+	}
+
+	rmsg := network.NewReply(
+		msg,
+		MsgGetMetrics|MaskBit,
+		data,
+	)
+
+	conn.Write(rmsg)
 }
 
 func tryUpdateBlock(node network.Node, mempool kernel.Mempool, height kernel.Height) {
@@ -465,9 +813,7 @@ func tryUpdateBlock(node network.Node, mempool kernel.Mempool, height kernel.Hei
 		return
 	}
 
-	lastBlock := Chain.Block(height)
-
-	newBlock := kernel.NewBlock(lastBlock.Hash(), txs)
+	newBlock := Chain.Propose(txs)
 	newHeight := height + 1
 
 	ok := Chain.Accept(newBlock)
@@ -491,6 +837,24 @@ func tryUpdateBlock(node network.Node, mempool kernel.Mempool, height kernel.Hei
 	node.Broadcast(network.NewMessage(MsgSetBlock, upBlockBytes))
 }
 
+// Identity of node in transport handshakes,
+// created on first start and kept out of the chain directory.
+func loadNodeKey(path string) crypto.PrivKey {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if _, err := x509.ParsePKCS1PrivateKey(data); err != nil {
+			panic("node key is invalid")
+		}
+		return crypto.LoadPrivKey(data)
+	}
+
+	priv := crypto.NewPrivKey(kernel.KeySize)
+	if err := os.WriteFile(path, priv.Bytes(), 0600); err != nil {
+		panic(err)
+	}
+	return priv
+}
+
 func pathIsExist(path string) bool {
 	_, err := os.Stat(path)
 	return !os.IsNotExist(err)
@@ -508,6 +872,7 @@ func newGenesis() kernel.Block {
 	}
 	return kernel.NewBlock(
 		[]byte("genesis.block"),
+		nil,
 		txs,
 	)
 }
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/number571/union-bc/kernel"
+	"github.com/number571/union-bc/network"
+)
+
+type mempoolRequest struct {
+	Command   string `json:"command"`
+	Hash      []byte `json:"hash,omitempty"`
+	Validator []byte `json:"validator,omitempty"`
+	Offset    uint64 `json:"offset,omitempty"`
+	Limit     uint64 `json:"limit,omitempty"`
+}
+
+type mempoolReply struct {
+	Error   string               `json:"error,omitempty"`
+	Entries []mempoolEntry       `json:"entries,omitempty"`
+	Stats   *kernel.MempoolStats `json:"stats,omitempty"`
+}
+
+type mempoolEntry struct {
+	Hash    string        `json:"hash"`
+	Seq     uint64        `json:"seq"`
+	Time    int64         `json:"time"`
+	Fee     uint64        `json:"fee"`
+	Size    int           `json:"size"`
+	Type    kernel.TXType `json:"type"`
+	Payload string        `json:"payload,omitempty"`
+	TX      []byte        `json:"tx,omitempty"`
+}
+
+// Ordering and eviction policies of mempool are set by environment
+// variables, FIFO and reject by default. Called after chain is opened.
+func initMempool() {
+	mempool := Chain.Mempool()
+
+	switch os.Getenv(PolicyEnv) {
+	case "", "fifo":
+		mempool.SetPolicy(kernel.NewFIFOPolicy())
+	case "fee":
+		mempool.SetPolicy(kernel.NewFeePolicy())
+	case "fair":
+		mempool.SetPolicy(kernel.NewFairPolicy())
+	default:
+		panic("mempool policy is invalid")
+	}
+
+	switch os.Getenv(EvictionEnv) {
+	case "", "reject":
+		mempool.SetEviction(kernel.EvictReject)
+	case "oldest":
+		mempool.SetEviction(kernel.EvictOldest)
+	case "lowest":
+		mempool.SetEviction(kernel.EvictLowest)
+	default:
+		panic("mempool eviction is invalid")
+	}
+}
+
+// Mempool commands over the network protocol.
+// Commands drop and clear are accepted only from admin.
+func handleMempool(node network.Node, conn network.Conn, msg network.Message) {
+	var (
+		mempool = Chain.Mempool()
+		request = mempoolRequest{}
+		reply   = mempoolReply{}
+	)
+
+	defer func(conn network.Conn) {
+		data, err := json.Marshal(reply)
+		if err != nil {
+			return
+		}
+		conn.Write(network.NewReply(msg, MsgMempool|MaskBit, data))
+	}(conn)
+
+	err := json.Unmarshal(msg.Body(), &request)
+	if err != nil {
+		reply.Error = "invalid request"
+		return
+	}
+
+	switch request.Command {
+	case "list":
+		reply.Entries = toMempoolEntries(mempool.List(request.Offset, request.Limit), false)
+	case "signer":
+		pub := kernel.LoadPubKey(request.Validator)
+		if pub == nil {
+			reply.Error = "invalid validator"
+			return
+		}
+		reply.Entries = toMempoolEntries(mempool.ListBySigner(pub, request.Offset, request.Limit), false)
+	case "show":
+		entry, ok := mempool.Entry(request.Hash)
+		if !ok {
+			reply.Error = "tx not found"
+			return
+		}
+		reply.Entries = toMempoolEntries([]kernel.MempoolEntry{entry}, true)
+	case "stats":
+		stats := mempool.Stats()
+		reply.Stats = &stats
+	case "drop", "clear":
+		if !isAdmin(conn) {
+			reply.Error = "permission denied"
+			return
+		}
+		if request.Command == "clear" {
+			mempool.Clear()
+			return
+		}
+		if mempool.TX(request.Hash) == nil {
+			reply.Error = "tx not found"
+			return
+		}
+		mempool.Delete(request.Hash)
+	default:
+		reply.Error = "unknown command"
+	}
+}
+
+// Client of mempool commands to running node.
+func mempoolCommand(args []string) int {
+	const usage = "usage: mempool list [OFFSET [LIMIT]] | signer PUBKEY [OFFSET [LIMIT]] | show HASH | drop HASH | clear | stats"
+
+	if len(args) < 1 {
+		fmt.Println(usage)
+		return 1
+	}
+
+	request := mempoolRequest{Command: args[0]}
+	switch request.Command {
+	case "list":
+		if !parsePage(args[1:], &request) {
+			fmt.Println(usage)
+			return 1
+		}
+	case "signer", "show", "drop":
+		if len(args) < 2 {
+			fmt.Println(usage)
+			return 1
+		}
+		data, err := hex.DecodeString(args[1])
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if request.Command == "signer" {
+			request.Validator = data
+			if !parsePage(args[2:], &request) {
+				fmt.Println(usage)
+				return 1
+			}
+		} else {
+			request.Hash = data
+		}
+	case "clear", "stats":
+	default:
+		fmt.Println(usage)
+		return 1
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	conn := network.NewConn(NodeKey, Address)
+	if conn == nil {
+		fmt.Println("node is not available")
+		return 1
+	}
+	defer conn.Close()
+
+	msg, err := conn.Request(network.NewMessage(MsgMempool, data))
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	reply := mempoolReply{}
+	if err := json.Unmarshal(msg.Body(), &reply); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	out, err := json.MarshalIndent(reply, "", "\t")
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Println(string(out))
+
+	if reply.Error != "" {
+		return 1
+	}
+	return 0
+}
+
+func parsePage(args []string, request *mempoolRequest) bool {
+	nums := []*uint64{&request.Offset, &request.Limit}
+	for i, arg := range args {
+		if i >= len(nums) {
+			return false
+		}
+		num, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return false
+		}
+		*nums[i] = num
+	}
+	return true
+}
+
+func toMempoolEntries(entries []kernel.MempoolEntry, withTX bool) []mempoolEntry {
+	result := []mempoolEntry{}
+	for _, entry := range entries {
+		txBytes := entry.TX.Bytes()
+		info := mempoolEntry{
+			Hash: fmt.Sprintf("%X", entry.TX.Hash()),
+			Seq:  entry.Seq,
+			Time: entry.Time,
+			Fee:  entry.TX.Fee(),
+			Size: len(txBytes),
+			Type: entry.TX.Type(),
+		}
+		if withTX {
+			info.Payload = Schemas.Render(entry.TX)
+			info.TX = txBytes
+		}
+		result = append(result, info)
+	}
+	return result
+}
+
+// Connection authenticated by the key of this node. Loopback
+// is not trusted, local nodes of other keys connect from it.
+func isAdmin(conn network.Conn) bool {
+	return conn.PubKey().Equal(NodeKey.PubKey())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/number571/union-bc/network"
+)
+
+var (
+	Metrics = &metrics{}
+)
+
+type metrics struct {
+	TXsAccepted  uint64 `json:"txs_accepted"`
+	TXsInvalid   uint64 `json:"txs_invalid"`
+	TXsRejected  uint64 `json:"txs_rejected"`
+	TXsMalformed uint64 `json:"txs_malformed"`
+	TXsInChain   uint64 `json:"txs_in_chain"`
+	TXsInMempool uint64 `json:"txs_in_mempool"`
+	MempoolFull  uint64 `json:"mempool_full"`
+	SenderQuota  uint64 `json:"sender_quota"`
+	ConnQuota    uint64 `json:"conn_quota"`
+	RateLimited  uint64 `json:"rate_limited"`
+
+	Queues []queueMetrics `json:"queues,omitempty"`
+}
+
+// Send queue of peer connection.
+type queueMetrics struct {
+	NodeID  string `json:"node_id"`
+	Address string `json:"address"`
+	network.QueueStats
+}
+
+func (m *metrics) Inc(counter *uint64) {
+	atomic.AddUint64(counter, 1)
+}
+
+// Counters with send queues of peers.
+func (m *metrics) Snapshot(node network.Node) metrics {
+	snapshot := metrics{
+		TXsAccepted:  atomic.LoadUint64(&m.TXsAccepted),
+		TXsInvalid:   atomic.LoadUint64(&m.TXsInvalid),
+		TXsRejected:  atomic.LoadUint64(&m.TXsRejected),
+		TXsMalformed: atomic.LoadUint64(&m.TXsMalformed),
+		TXsInChain:   atomic.LoadUint64(&m.TXsInChain),
+		TXsInMempool: atomic.LoadUint64(&m.TXsInMempool),
+		MempoolFull:  atomic.LoadUint64(&m.MempoolFull),
+		SenderQuota:  atomic.LoadUint64(&m.SenderQuota),
+		ConnQuota:    atomic.LoadUint64(&m.ConnQuota),
+		RateLimited:  atomic.LoadUint64(&m.RateLimited),
+	}
+
+	for _, conn := range node.Connections() {
+		snapshot.Queues = append(snapshot.Queues, queueMetrics{
+			NodeID:     conn.Hello().NodeID,
+			Address:    conn.Address(),
+			QueueStats: conn.Stats(),
+		})
+	}
+
+	return snapshot
+}
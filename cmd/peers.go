@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/number571/union-bc/network"
+)
+
+// Addresses from environment variable separated by commas,
+// by default the local nodes of ListAddr.
+func seedList() []string {
+	seeds := ListAddr
+	if env := os.Getenv(SeedsEnv); env != "" {
+		seeds = strings.Split(env, ",")
+	}
+
+	result := []string{}
+	for _, seed := range seeds {
+		addr, ok := normalizeAddr(strings.TrimSpace(seed))
+		if !ok || isSelfAddr(addr) {
+			continue
+		}
+		result = append(result, addr)
+	}
+	return result
+}
+
+// Seeds first, then the best addresses of book.
+func bootstrapList() []string {
+	var (
+		result = []string{}
+		seen   = make(map[string]bool)
+	)
+
+	for _, addr := range append(seedList(), Peers.Select(PeersNum)...) {
+		if seen[addr] || isSelfAddr(addr) || Peers.IsBanned(addr) {
+			continue
+		}
+		seen[addr] = true
+		result = append(result, addr)
+	}
+	return result
+}
+
+// Connections asked for addresses, each request
+// is answered by one MsgAddr.
+type addrRequests struct {
+	mtx   sync.Mutex
+	conns map[network.Conn]bool
+}
+
+func newAddrRequests() *addrRequests {
+	return &addrRequests{
+		conns: make(map[network.Conn]bool),
+	}
+}
+
+func (reqs *addrRequests) Add(conn network.Conn) {
+	reqs.mtx.Lock()
+	defer reqs.mtx.Unlock()
+
+	_, ok := reqs.conns[conn]
+	reqs.conns[conn] = true
+	if ok {
+		return
+	}
+
+	go func() {
+		<-conn.Done()
+
+		reqs.mtx.Lock()
+		defer reqs.mtx.Unlock()
+
+		delete(reqs.conns, conn)
+	}()
+}
+
+// Request of connection is answered, false if there was none.
+func (reqs *addrRequests) Take(conn network.Conn) bool {
+	reqs.mtx.Lock()
+	defer reqs.mtx.Unlock()
+
+	if !reqs.conns[conn] {
+		return false
+	}
+	reqs.conns[conn] = false
+	return true
+}
+
+// Request addresses of new outbound peer.
+func requestAddr(conn network.Conn) {
+	AddrRequests.Add(conn)
+	conn.Write(network.NewMessage(MsgGetAddr, nil))
+}
+
+// Ask random peer for addresses, connections
+// to them are made by peer manager.
+func runDiscovery(node network.Node) {
+	for {
+		time.Sleep(AddrInterval * time.Second)
+
+		conns := node.Connections()
+		if len(conns) != 0 {
+			requestAddr(conns[rand.Intn(len(conns))])
+		}
+
+		Peers.Save()
+	}
+}
+
+// Reply with sample of known addresses. Listening
+// address of requesting node is added to the book.
+func handleGetAddr(node network.Node, conn network.Conn, msg network.Message) {
+	if conn.Hello().Role == network.IsNode {
+		if addr := peerAddr(conn); addr != "" {
+			Peers.Add(addr, addr)
+		}
+	}
+
+	data, err := json.Marshal(Peers.Sample(AddrSize))
+	if err != nil {
+		return
+	}
+
+	conn.Write(network.NewMessage(MsgAddr, data))
+}
+
+// Addresses are accepted only from nodes asked by MsgGetAddr.
+func handleAddr(node network.Node, conn network.Conn, msg network.Message) {
+	var (
+		addrs  []string
+		source = peerAddr(conn)
+	)
+
+	if conn.Hello().Role != network.IsNode || !AddrRequests.Take(conn) {
+		return
+	}
+
+	err := json.Unmarshal(msg.Body(), &addrs)
+	if err != nil || len(addrs) > AddrSize {
+		node.Misbehave(conn, PenaltyMalformed, "malformed addresses")
+		return
+	}
+
+	for _, addr := range addrs {
+		addr, ok := toldAddr(addr, conn)
+		if !ok || isSelfAddr(addr) {
+			continue
+		}
+		Peers.Add(addr, source)
+	}
+}
+
+// Listening address of peer: host of connection with port of hello.
+func peerAddr(conn network.Conn) string {
+	host, _, err := net.SplitHostPort(conn.Address())
+	if err != nil {
+		return ""
+	}
+
+	lhost, lport, err := net.SplitHostPort(conn.Hello().Address)
+	if err != nil || lport == "" {
+		return ""
+	}
+
+	if lhost != "" {
+		host = lhost
+	}
+
+	addr, _ := normalizeAddr(net.JoinHostPort(host, lport))
+	return addr
+}
+
+// Address told by peer, address without host is on host of peer.
+func toldAddr(addr string, conn network.Conn) (string, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" {
+		return "", false
+	}
+
+	if host == "" {
+		host, _, err = net.SplitHostPort(conn.Address())
+		if err != nil {
+			return "", false
+		}
+	}
+
+	return net.JoinHostPort(host, port), true
+}
+
+// Address without host is address of local host.
+func normalizeAddr(addr string) (string, bool) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" {
+		return "", false
+	}
+
+	if host == "" {
+		host = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(host, port), true
+}
+
+func isSelfAddr(addr string) bool {
+	self, ok := normalizeAddr(Address)
+	return ok && self == addr
+}
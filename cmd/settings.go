@@ -7,11 +7,89 @@ const (
 	MsgSetBlock  = 0x04
 	MsgGetTX     = 0x05
 	MsgSetTX     = 0x06
+
+	MsgGetSignerTXs   = 0x07
+	MsgGetBlockByHash = 0x08
+	MsgGetMetrics     = 0x09
+	MsgInvTX          = 0x0A
+	MsgGetInvTX       = 0x0B
+	MsgPushTXs        = 0x0C
+	MsgMempool        = 0x0D
+	MsgSubscribe      = 0x0E
+	MsgEvent          = 0x0F
+	MsgQueryKV        = 0x10
+	MsgGetTypeTXs     = 0x11
+	MsgGetAddr        = 0x12
+	MsgAddr           = 0x13
+	MsgBans           = 0x14
+)
+
+const (
+	RetOK          = 0
+	RetTXInvalid   = 2
+	RetTXInChain   = 3
+	RetTXInMempool = 4
+	RetMempoolFull = 5
+	RetSenderQuota = 6
+	RetConnQuota   = 7
+	RetRateLimited = 8
+	RetTXRejected  = 9
+	RetTXMalformed = 10
+)
+
+const (
+	ConnRate        = 10  // txs in second from one connection
+	ConnBurst       = 20  // txs at once from one connection
+	ConnPendingSize = 256 // txs in mempool from one connection
+	SenderRate      = 5   // txs in second from one validator
+	SenderBurst     = 10  // txs at once from one validator
+	LimiterSize     = 4096
+)
+
+const (
+	EventsSize  = 256 // buffered events of one subscriber
+	InvSize     = 256 // hashes in one announcement
+	InvInterval = 500 // milliseconds
+)
+
+const (
+	PolicyEnv   = "UNION_POLICY"   // mempool order: fifo, fee or fair
+	EvictionEnv = "UNION_EVICTION" // full mempool: reject, oldest or lowest
+)
+
+const (
+	FanoutEnv = "UNION_FANOUT" // peers receiving broadcast, 0 for all
+	QueueEnv  = "UNION_QUEUE"  // full send queue: drop or disconnect
+)
+
+const (
+	SeedsEnv     = "UNION_SEEDS" // seed addresses separated by commas
+	PeersNum     = 8             // outbound connections to keep
+	AddrSize     = 64            // addresses in one reply
+	AddrInterval = 30            // seconds
+)
+
+const (
+	PenaltyMalformed = 10 // body of message is not decoded
+	PenaltyTX        = 5  // invalid transaction
+	PenaltyBlock     = 25 // invalid block
+)
+
+const (
+	CapRelay   = "relay"   // mempool inventory relay
+	CapEvents  = "events"  // chain events stream
+	CapMempool = "mempool" // mempool commands
+	CapKV      = "kv"      // key-value application
+)
+
+var (
+	Capabilities = []string{CapRelay, CapEvents, CapMempool, CapKV}
 )
 
 const (
 	MaskBit      = (1 << 31)
 	IntervalTime = 5 // seconds
+	ShutdownTime = 5 // seconds
 	ClientsNum   = 3
 	TXsInSecond  = 3
 )
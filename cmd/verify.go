@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/number571/union-bc/kernel"
+)
+
+type verifyReport struct {
+	Path       string         `json:"path"`
+	Height     kernel.Height  `json:"height"`
+	Consistent bool           `json:"consistent"`
+	Issues     []kernel.Issue `json:"issues"`
+}
+
+// Print report of chain inconsistencies in JSON format.
+// Returns exit code: 0 if chain is consistent (or repaired), 1 otherwise.
+func verifyChain(repair bool) int {
+	defer Chain.Close()
+
+	issues := Chain.Verify(repair)
+
+	report := verifyReport{
+		Path:       ChainPath,
+		Consistent: true,
+		Issues:     issues,
+	}
+
+	for _, issue := range issues {
+		if !issue.Fixed {
+			report.Consistent = false
+			break
+		}
+	}
+
+	if report.Consistent {
+		report.Height = Chain.Height()
+	}
+
+	if report.Issues == nil {
+		report.Issues = []kernel.Issue{}
+	}
+
+	data, err := json.MarshalIndent(report, "", "\t")
+	if err != nil {
+		return 1
+	}
+
+	fmt.Println(string(data))
+
+	if !report.Consistent {
+		return 1
+	}
+	return 0
+}
@@ -1,6 +1,6 @@
 module github.com/number571/union-bc
 
-go 1.17
+go 1.20
 
 require (
 	github.com/number571/go-peer v1.3.8
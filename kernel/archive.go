@@ -0,0 +1,174 @@
+package kernel
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/number571/go-peer/encoding"
+)
+
+var (
+	_ ArchiveWriter = &ArchiveWriterT{}
+	_ ArchiveReader = &ArchiveReaderT{}
+)
+
+var (
+	ErrArchiveMagic  = errors.New("archive: invalid magic")
+	ErrArchiveSize   = errors.New("archive: record size exceeded")
+	ErrArchiveBlock  = errors.New("archive: invalid block")
+	ErrArchiveGap    = errors.New("archive: height gap")
+	ErrArchiveRange  = errors.New("archive: invalid range")
+	ErrArchiveAccept = errors.New("archive: block not accepted")
+	ErrGenesisDiffer = errors.New("archive: genesis block differs")
+	ErrChainBlock    = errors.New("archive: block is missing in chain")
+)
+
+// Archive is a magic header followed by records of
+// [height:8][size:8][block bytes:size] in big endian.
+type ArchiveWriterT struct {
+	ptr    io.Writer
+	header bool
+}
+
+type ArchiveReaderT struct {
+	ptr    io.Reader
+	header bool
+}
+
+func NewArchiveWriter(w io.Writer) ArchiveWriter {
+	return &ArchiveWriterT{ptr: w}
+}
+
+func NewArchiveReader(r io.Reader) ArchiveReader {
+	return &ArchiveReaderT{ptr: r}
+}
+
+func (archive *ArchiveWriterT) Write(height Height, block Block) error {
+	if !archive.header {
+		if _, err := archive.ptr.Write([]byte(ArchiveMagic)); err != nil {
+			return err
+		}
+		archive.header = true
+	}
+
+	blockBytes := block.Bytes()
+	if uint64(len(blockBytes)) > ArchiveBlockSize {
+		return ErrArchiveSize
+	}
+
+	_, err := archive.ptr.Write(bytes.Join(
+		[][]byte{
+			encoding.Uint64ToBytes(uint64(height)),
+			encoding.Uint64ToBytes(uint64(len(blockBytes))),
+			blockBytes,
+		},
+		[]byte{},
+	))
+	return err
+}
+
+// Returns io.EOF when the archive has no more records.
+func (archive *ArchiveReaderT) Read() (Height, Block, error) {
+	if !archive.header {
+		magic := make([]byte, len(ArchiveMagic))
+		if _, err := io.ReadFull(archive.ptr, magic); err != nil {
+			return 0, nil, ErrArchiveMagic
+		}
+		if string(magic) != ArchiveMagic {
+			return 0, nil, ErrArchiveMagic
+		}
+		archive.header = true
+	}
+
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(archive.ptr, head); err != nil {
+		return 0, nil, err
+	}
+
+	var (
+		height = Height(encoding.BytesToUint64(head[:8]))
+		size   = encoding.BytesToUint64(head[8:])
+	)
+
+	if size > ArchiveBlockSize {
+		return 0, nil, ErrArchiveSize
+	}
+
+	blockBytes := make([]byte, size)
+	if _, err := io.ReadFull(archive.ptr, blockBytes); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	block := LoadBlock(blockBytes)
+	if block == nil {
+		return 0, nil, ErrArchiveBlock
+	}
+
+	return height, block, nil
+}
+
+// Write blocks in range [begin, end] of the chain to archive.
+func ExportBlocks(chain Chain, archive ArchiveWriter, begin, end Height) (uint64, error) {
+	if begin > end || end > chain.Height() {
+		return 0, ErrArchiveRange
+	}
+
+	count := uint64(0)
+	for i := begin; i <= end; i++ {
+		block := chain.Block(i)
+		if block == nil {
+			return count, ErrArchiveBlock
+		}
+
+		if err := archive.Write(i, block); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// Accept blocks from archive into the chain. Blocks that are already
+// in the chain are skipped, the archive must continue the chain without gaps.
+func ImportBlocks(chain Chain, archive ArchiveReader) (uint64, error) {
+	count := uint64(0)
+
+	for {
+		height, block, err := archive.Read()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		currHeight := chain.Height()
+		if height <= currHeight {
+			currBlock := chain.Block(height)
+			if currBlock == nil {
+				return count, ErrChainBlock
+			}
+			if !bytes.Equal(currBlock.Hash(), block.Hash()) {
+				if height == 0 {
+					return count, ErrGenesisDiffer
+				}
+				return count, ErrArchiveAccept
+			}
+			continue
+		}
+
+		if height != currHeight+1 {
+			return count, ErrArchiveGap
+		}
+
+		if !chain.Accept(block) {
+			return count, ErrArchiveAccept
+		}
+		count++
+	}
+}
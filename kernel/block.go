@@ -14,18 +14,22 @@ var (
 )
 
 type BlockT struct {
-	txs      []Transaction
-	prevHash []byte
-	currHash []byte
+	txs       []Transaction
+	prevHash  []byte
+	stateRoot []byte
+	currHash  []byte
 }
 
 type blockJSON struct {
-	TXs      [][]byte `json:"txs"`
-	PrevHash []byte   `json:"prev_hash"`
-	CurrHash []byte   `json:"curr_hash"`
+	TXs       [][]byte `json:"txs"`
+	PrevHash  []byte   `json:"prev_hash"`
+	StateRoot []byte   `json:"state_root,omitempty"`
+	CurrHash  []byte   `json:"curr_hash"`
 }
 
-func NewBlock(prevHash []byte, txs []Transaction) Block {
+// State root is root of application state after transactions
+// of block, empty for chains without application.
+func NewBlock(prevHash, stateRoot []byte, txs []Transaction) Block {
 	if len(txs) != TXsSize {
 		return nil
 	}
@@ -50,8 +54,9 @@ func NewBlock(prevHash []byte, txs []Transaction) Block {
 	}
 
 	block := &BlockT{
-		txs:      txs,
-		prevHash: prevHash,
+		txs:       txs,
+		prevHash:  prevHash,
+		stateRoot: stateRoot,
 	}
 
 	block.currHash = block.newHash()
@@ -66,8 +71,9 @@ func LoadBlock(blockBytes []byte) Block {
 	}
 
 	block := &BlockT{
-		prevHash: blockConv.PrevHash,
-		currHash: blockConv.CurrHash,
+		prevHash:  blockConv.PrevHash,
+		stateRoot: blockConv.StateRoot,
+		currHash:  blockConv.CurrHash,
 	}
 
 	for _, tx := range blockConv.TXs {
@@ -96,10 +102,15 @@ func (block *BlockT) PrevHash() Hash {
 	return block.prevHash
 }
 
+func (block *BlockT) StateRoot() Hash {
+	return block.stateRoot
+}
+
 func (block *BlockT) Bytes() []byte {
 	blockConv := &blockJSON{
-		PrevHash: block.PrevHash(),
-		CurrHash: block.Hash(),
+		PrevHash:  block.PrevHash(),
+		StateRoot: block.StateRoot(),
+		CurrHash:  block.Hash(),
 	}
 
 	for _, tx := range block.txs {
@@ -138,6 +149,7 @@ func (block *BlockT) newHash() Hash {
 	hash := bytes.Join(
 		[][]byte{
 			block.PrevHash(),
+			block.StateRoot(),
 		},
 		[]byte{},
 	)
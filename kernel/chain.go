@@ -19,7 +19,9 @@ type ChainT struct {
 	path    string
 	blocks  KeyValueDB
 	txs     KeyValueDB
-	mempool Mempool
+	events  *eventsT
+	app     Application
+	mempool *MempoolT
 }
 
 func NewChain(path string, genesis Block) Chain {
@@ -52,18 +54,18 @@ func NewChain(path string, genesis Block) Chain {
 		return nil
 	}
 
+	events := newEvents()
 	chain := &ChainT{
-		path:   path,
-		blocks: blocks,
-		txs:    txs,
-		mempool: &MempoolT{
-			ptr: mempool,
-		},
+		path:    path,
+		blocks:  blocks,
+		txs:     txs,
+		events:  events,
+		mempool: newMempool(mempool, events),
 	}
 
 	chain.setHeight(0)
-	chain.setBlock(genesis)
-	mempool.Set(GetKeyMempoolHeight(), encoding.Uint64ToBytes(0))
+	chain.setBlock(0, genesis)
+	chain.setIndexVersion()
 
 	return chain
 }
@@ -90,22 +92,24 @@ func LoadChain(path string) Chain {
 		return nil
 	}
 
-	return &ChainT{
-		path:   path,
-		blocks: blocks,
-		txs:    txs,
-		mempool: &MempoolT{
-			ptr: mempool,
-		},
+	events := newEvents()
+	chain := &ChainT{
+		path:    path,
+		blocks:  blocks,
+		txs:     txs,
+		events:  events,
+		mempool: newMempool(mempool, events),
 	}
+
+	chain.indexBlocks()
+	return chain
 }
 
 func (chain *ChainT) Close() {
 	chain.blocks.Close()
 	chain.txs.Close()
 
-	mempool := chain.mempool.(*MempoolT)
-	mempool.ptr.Close()
+	chain.mempool.ptr.Close()
 }
 
 func (chain *ChainT) Rollback(ptr uint64) bool {
@@ -126,9 +130,70 @@ func (chain *ChainT) Rollback(ptr uint64) bool {
 		chain.delBlock(i)
 	}
 
+	if hptr != 0 {
+		chain.rollbackState(newHeight)
+	}
+
+	if block := chain.getBlock(newHeight); hptr != 0 && block != nil {
+		chain.events.publish(Event{
+			Kind:   EventRollback,
+			Height: newHeight,
+			Hash:   block.Hash(),
+		})
+	}
+
 	return true
 }
 
+// Attach application and replay blocks which it has not committed.
+// Application state above the chain height is rolled back.
+func (chain *ChainT) SetApplication(app Application) {
+	chain.mtx.Lock()
+	defer chain.mtx.Unlock()
+
+	var (
+		height = chain.Height()
+		begin  = Height(0)
+	)
+
+	appHeight, ok := app.Info()
+	if ok {
+		if appHeight > height {
+			app.Rollback(height)
+			appHeight = height
+		}
+		begin = appHeight + 1
+	}
+
+	chain.app = app
+	chain.mempool.setApplication(app)
+
+	for i := begin; i <= height; i++ {
+		block := chain.getBlock(i)
+		if block == nil {
+			panic("chain: block undefined")
+		}
+		chain.applyBlock(i, block)
+	}
+}
+
+// State root of application after block at height.
+func (chain *ChainT) StateRoot(height Height) Hash {
+	return chain.blocks.Get(GetKeyRoot(height))
+}
+
+// Subscribe to chain and mempool events with buffer of size.
+// Subscription must be closed when it is no longer needed.
+func (chain *ChainT) Subscribe(size uint64) Subscription {
+	return chain.events.subscribe(size)
+}
+
+// Subscriptions of chain receive events of other chain,
+// used when chain is replaced by new one.
+func (chain *ChainT) MoveSubscriptions(other Chain) {
+	chain.events.moveTo(other.(*ChainT).events)
+}
+
 func (chain *ChainT) Mempool() Mempool {
 	return chain.mempool
 }
@@ -156,17 +221,60 @@ func (chain *ChainT) Accept(block Block) bool {
 		}
 	}
 
+	height := chain.Height() + 1
+
+	// Block commits state of application after its transactions.
+	var root Hash
+	if chain.app != nil {
+		root = chain.deliverTXs(height, block.Transactions())
+		if !bytes.Equal(root, block.StateRoot()) {
+			chain.revertBlock(height)
+			return false
+		}
+	}
+
 	mempool := chain.Mempool()
 	for _, tx := range block.Transactions() {
 		mempool.Delete(tx.Hash())
 	}
 
-	chain.setHeight(chain.Height() + 1)
-	chain.setBlock(block)
+	chain.setHeight(height)
+	chain.setBlock(height, block)
+	if root != nil {
+		chain.blocks.Set(GetKeyRoot(height), root)
+	}
+
+	chain.events.publish(Event{
+		Kind:   EventBlockAccepted,
+		Height: height,
+		Hash:   block.Hash(),
+	})
 
 	return true
 }
 
+// Next block of transactions with state root after them,
+// state of application is not changed.
+func (chain *ChainT) Propose(txs []Transaction) Block {
+	chain.mtx.Lock()
+	defer chain.mtx.Unlock()
+
+	var (
+		height    = chain.Height() + 1
+		lastBlock = chain.getBlock(height - 1)
+	)
+
+	block := NewBlock(lastBlock.Hash(), nil, txs)
+	if block == nil || chain.app == nil {
+		return block
+	}
+
+	root := chain.deliverTXs(height, block.Transactions())
+	chain.revertBlock(height)
+
+	return NewBlock(lastBlock.Hash(), root, block.Transactions())
+}
+
 func (chain *ChainT) Merge(height Height, txs []Transaction) bool {
 	chain.mtx.Lock()
 	defer chain.mtx.Unlock()
@@ -198,14 +306,40 @@ func (chain *ChainT) Merge(height Height, txs []Transaction) bool {
 		return false
 	}
 
+	// Merged block is the same on every node: greater fee first,
+	// equal fees by hash. Local policy of mempool is not used here.
 	sort.SliceStable(resultTXs, func(i, j int) bool {
+		if resultTXs[i].Fee() != resultTXs[j].Fee() {
+			return resultTXs[i].Fee() > resultTXs[j].Fee()
+		}
 		return bytes.Compare(resultTXs[i].Hash(), resultTXs[j].Hash()) < 0
 	})
 
 	appendTXs := resultTXs[:TXsSize]
 	deleteTXs := resultTXs[TXsSize:]
 
-	chain.updateBlock(height, NewBlock(lastBlock.PrevHash(), appendTXs), deleteTXs)
+	sort.SliceStable(appendTXs, func(i, j int) bool {
+		return bytes.Compare(appendTXs[i].Hash(), appendTXs[j].Hash()) < 0
+	})
+
+	var root Hash
+	if chain.app != nil {
+		chain.revertBlock(height)
+		root = chain.deliverTXs(height, appendTXs)
+	}
+
+	mergedBlock := NewBlock(lastBlock.PrevHash(), root, appendTXs)
+	chain.updateBlock(height, mergedBlock, deleteTXs)
+	if root != nil {
+		chain.blocks.Set(GetKeyRoot(height), root)
+	}
+
+	chain.events.publish(Event{
+		Kind:   EventBlockMerged,
+		Height: height,
+		Hash:   mergedBlock.Hash(),
+	})
+
 	return true
 }
 
@@ -221,6 +355,77 @@ func (chain *ChainT) Block(height Height) Block {
 	return chain.getBlock(height)
 }
 
+func (chain *ChainT) BlockHeight(hash Hash) (Height, bool) {
+	height, ok := chain.getBlockHeight(hash)
+	if !ok || height > chain.Height() {
+		return 0, false
+	}
+	return height, true
+}
+
+func (chain *ChainT) BlockByHash(hash Hash) Block {
+	height, ok := chain.BlockHeight(hash)
+	if !ok {
+		return nil
+	}
+	return chain.getBlock(height)
+}
+
+func (chain *ChainT) TXLocation(hash Hash) (Location, bool) {
+	return chain.getLocation(hash)
+}
+
+// Transactions signed by the public key, ordered by block height.
+func (chain *ChainT) TXsBySigner(pub PubKey, offset, limit uint64) []TXEntry {
+	return chain.txsByIndex(GetKeyPrefixSigner(pub), offset, limit)
+}
+
+// Transactions of the type, ordered by block height.
+// Transactions without type are not indexed.
+func (chain *ChainT) TXsByType(txType TXType, offset, limit uint64) []TXEntry {
+	if txType == TXTypeRaw {
+		return nil
+	}
+	return chain.txsByIndex(GetKeyPrefixType(txType), offset, limit)
+}
+
+func (chain *ChainT) txsByIndex(prefix []byte, offset, limit uint64) []TXEntry {
+	if limit == 0 || limit > PageSize {
+		limit = PageSize
+	}
+
+	var (
+		entries []TXEntry
+		count   uint64
+	)
+
+	iter := chain.txs.Iter(prefix)
+	defer iter.Close()
+
+	for iter.Next() {
+		if count < offset {
+			count++
+			continue
+		}
+
+		if uint64(len(entries)) == limit {
+			break
+		}
+
+		height, ok := parseKeyIndexHeight(iter.Key())
+		if !ok {
+			continue
+		}
+
+		entries = append(entries, TXEntry{
+			Height: height,
+			Hash:   copyBytes(iter.Value()),
+		})
+	}
+
+	return entries
+}
+
 // Height
 
 func (chain *ChainT) getHeight() Height {
@@ -242,12 +447,60 @@ func (chain *ChainT) getTX(hash Hash) Transaction {
 	return LoadTransaction(data)
 }
 
-func (chain *ChainT) setTX(tx Transaction) {
+func (chain *ChainT) setTX(loc Location, tx Transaction) {
 	chain.txs.Set(GetKeyTX(tx.Hash()), tx.Bytes())
+	chain.txs.Set(GetKeyLocation(tx.Hash()), loc.Bytes())
+	chain.txs.Set(GetKeySigner(tx.Validator(), loc.Height, tx.Hash()), tx.Hash())
+	if tx.Type() != TXTypeRaw {
+		chain.txs.Set(GetKeyType(tx.Type(), loc.Height, tx.Hash()), tx.Hash())
+	}
 }
 
-func (chain *ChainT) delTX(hash Hash) {
-	chain.txs.Del(GetKeyTX(hash))
+func (chain *ChainT) delTX(height Height, tx Transaction) {
+	chain.txs.Del(GetKeyTX(tx.Hash()))
+	chain.txs.Del(GetKeyLocation(tx.Hash()))
+	chain.txs.Del(GetKeySigner(tx.Validator(), height, tx.Hash()))
+	if tx.Type() != TXTypeRaw {
+		chain.txs.Del(GetKeyType(tx.Type(), height, tx.Hash()))
+	}
+}
+
+// Chains written before the block hash, location, signer and type
+// indexes get them by one walk over the blocks. Broken blocks are
+// skipped, they are reported by Verify.
+func (chain *ChainT) indexBlocks() {
+	data := chain.txs.Get(GetKeyIndexVersion())
+	if data != nil && encoding.BytesToUint64(data) >= IndexVersion {
+		return
+	}
+
+	data = chain.blocks.Get(GetKeyHeight())
+	if data == nil {
+		return
+	}
+
+	height := Height(encoding.BytesToUint64(data))
+	for i := Height(0); i <= height; i++ {
+		block := chain.getBlock(i)
+		if block == nil {
+			continue
+		}
+		chain.blocks.Set(GetKeyBlockHash(block.Hash()), encoding.Uint64ToBytes(uint64(i)))
+		for j, tx := range block.Transactions() {
+			chain.setTX(Location{i, uint64(j)}, tx)
+		}
+	}
+
+	chain.setIndexVersion()
+}
+
+func (chain *ChainT) setIndexVersion() {
+	chain.txs.Set(GetKeyIndexVersion(), encoding.Uint64ToBytes(IndexVersion))
+}
+
+func (chain *ChainT) getLocation(hash Hash) (Location, bool) {
+	data := chain.txs.Get(GetKeyLocation(hash))
+	return LoadLocation(data)
 }
 
 // Block
@@ -257,11 +510,12 @@ func (chain *ChainT) getBlock(height Height) Block {
 	return LoadBlock(data)
 }
 
-func (chain *ChainT) setBlock(block Block) {
-	chain.blocks.Set(GetKeyBlock(chain.Height()), block.Bytes())
+func (chain *ChainT) setBlock(height Height, block Block) {
+	chain.blocks.Set(GetKeyBlock(height), block.Bytes())
+	chain.blocks.Set(GetKeyBlockHash(block.Hash()), encoding.Uint64ToBytes(uint64(height)))
 
-	for _, tx := range block.Transactions() {
-		chain.setTX(tx)
+	for i, tx := range block.Transactions() {
+		chain.setTX(Location{height, uint64(i)}, tx)
 	}
 }
 
@@ -269,25 +523,91 @@ func (chain *ChainT) delBlock(height Height) {
 	block := chain.getBlock(height)
 
 	for _, tx := range block.Transactions() {
-		chain.delTX(tx.Hash())
+		chain.delTX(height, tx)
 	}
 
+	chain.blocks.Del(GetKeyBlockHash(block.Hash()))
 	chain.blocks.Del(GetKeyBlock(height))
 }
 
+func (chain *ChainT) getBlockHeight(hash Hash) (Height, bool) {
+	data := chain.blocks.Get(GetKeyBlockHash(hash))
+	if len(data) != 8 {
+		return 0, false
+	}
+	return Height(encoding.BytesToUint64(data)), true
+}
+
 func (chain *ChainT) updateBlock(height Height, block Block, delTXs []Transaction) {
-	mempool := chain.Mempool()
+	mempool := chain.mempool
+
+	if oldBlock := chain.getBlock(height); oldBlock != nil {
+		chain.blocks.Del(GetKeyBlockHash(oldBlock.Hash()))
+	}
+
 	chain.blocks.Set(GetKeyBlock(height), block.Bytes())
+	chain.blocks.Set(GetKeyBlockHash(block.Hash()), encoding.Uint64ToBytes(uint64(height)))
 
-	for _, tx := range block.Transactions() {
-		chain.setTX(tx)
+	for i, tx := range block.Transactions() {
+		chain.setTX(Location{height, uint64(i)}, tx)
 		mempool.Delete(tx.Hash())
 	}
 
 	for _, tx := range delTXs {
-		chain.delTX(tx.Hash())
-		mempool.Push(tx)
+		chain.delTX(height, tx)
+		mempool.restore(tx)
+	}
+}
+
+// Application
+
+// Application state and state roots above height are dropped.
+func (chain *ChainT) rollbackState(height Height) {
+	if chain.app != nil {
+		chain.app.Rollback(height)
+	}
+
+	var keys [][]byte
+
+	iter := chain.blocks.Iter([]byte(KeyPrefixRoot))
+	for iter.Next() {
+		i, ok := parseKeyHeight(iter.Key(), KeyPrefixRoot)
+		if ok && i > height {
+			keys = append(keys, copyBytes(iter.Key()))
+		}
+	}
+	iter.Close()
+
+	for _, key := range keys {
+		chain.blocks.Del(key)
+	}
+}
+
+func (chain *ChainT) applyBlock(height Height, block Block) {
+	if chain.app == nil {
+		return
+	}
+
+	root := chain.deliverTXs(height, block.Transactions())
+	chain.blocks.Set(GetKeyRoot(height), root)
+}
+
+// Commit transactions as block at height, returns state root.
+func (chain *ChainT) deliverTXs(height Height, txs []Transaction) Hash {
+	for _, tx := range txs {
+		// Invalid payloads stay in the block without effect on state.
+		_ = chain.app.DeliverTx(tx)
+	}
+	return chain.app.Commit(height)
+}
+
+// Application state is returned to state before block at height.
+func (chain *ChainT) revertBlock(height Height) {
+	if height == 0 {
+		chain.app.Reset()
+		return
 	}
+	chain.app.Rollback(height - 1)
 }
 
 func pathIsExist(path string) bool {
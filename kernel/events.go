@@ -0,0 +1,117 @@
+package kernel
+
+import (
+	"sync"
+)
+
+var (
+	_ Subscription = &SubscriptionT{}
+)
+
+const (
+	EventBlockAccepted = "block_accepted"
+	EventBlockMerged   = "block_merged"
+	EventRollback      = "rollback"
+	EventMempoolAdded  = "mempool_added"
+	EventMempoolEvict  = "mempool_evicted"
+	EventMempoolRemove = "mempool_removed"
+)
+
+// State change of chain or mempool.
+type Event struct {
+	Kind   string `json:"kind"`
+	Height Height `json:"height"`
+	Hash   Hash   `json:"hash"`
+}
+
+// Events are delivered without blocking the chain,
+// subscriber with full buffer loses new events.
+type eventsT struct {
+	mtx  sync.Mutex
+	subs map[*SubscriptionT]bool
+}
+
+type SubscriptionT struct {
+	mtx    sync.Mutex
+	closed bool
+	events *eventsT
+	ch     chan Event
+}
+
+func newEvents() *eventsT {
+	return &eventsT{
+		subs: make(map[*SubscriptionT]bool),
+	}
+}
+
+func (events *eventsT) subscribe(size uint64) Subscription {
+	sub := &SubscriptionT{
+		events: events,
+		ch:     make(chan Event, size),
+	}
+
+	events.mtx.Lock()
+	defer events.mtx.Unlock()
+
+	events.subs[sub] = true
+	return sub
+}
+
+func (events *eventsT) publish(event Event) {
+	events.mtx.Lock()
+	defer events.mtx.Unlock()
+
+	for sub := range events.subs {
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscriptions continue with events of other chain.
+func (events *eventsT) moveTo(other *eventsT) {
+	if events == other {
+		return
+	}
+
+	events.mtx.Lock()
+	subs := events.subs
+	events.subs = make(map[*SubscriptionT]bool)
+	events.mtx.Unlock()
+
+	for sub := range subs {
+		sub.mtx.Lock()
+		if !sub.closed {
+			sub.events = other
+			other.mtx.Lock()
+			other.subs[sub] = true
+			other.mtx.Unlock()
+		}
+		sub.mtx.Unlock()
+	}
+}
+
+func (events *eventsT) unsubscribe(sub *SubscriptionT) {
+	events.mtx.Lock()
+	defer events.mtx.Unlock()
+
+	delete(events.subs, sub)
+}
+
+func (sub *SubscriptionT) Events() <-chan Event {
+	return sub.ch
+}
+
+func (sub *SubscriptionT) Close() {
+	sub.mtx.Lock()
+	defer sub.mtx.Unlock()
+
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+
+	sub.events.unsubscribe(sub)
+	close(sub.ch)
+}
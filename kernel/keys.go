@@ -1,6 +1,12 @@
 package kernel
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/number571/go-peer/crypto"
+)
 
 func GetKeyHeight() []byte {
 	return []byte(KeyHeight)
@@ -10,10 +16,42 @@ func GetKeyBlock(height Height) []byte {
 	return []byte(fmt.Sprintf(KeyBlock, height))
 }
 
+func GetKeyRoot(height Height) []byte {
+	return []byte(fmt.Sprintf(KeyRoot, height))
+}
+
+func GetKeyBlockHash(hash Hash) []byte {
+	return []byte(fmt.Sprintf(KeyBlockHash, hash))
+}
+
 func GetKeyTX(hash Hash) []byte {
 	return []byte(fmt.Sprintf(KeyTX, hash))
 }
 
+func GetKeyLocation(hash Hash) []byte {
+	return []byte(fmt.Sprintf(KeyLocation, hash))
+}
+
+func GetKeySigner(pub PubKey, height Height, hash Hash) []byte {
+	return []byte(fmt.Sprintf(KeySigner, signerHash(pub), height, hash))
+}
+
+func GetKeyPrefixSigner(pub PubKey) []byte {
+	return []byte(fmt.Sprintf(KeyPrefixSigner, signerHash(pub)))
+}
+
+func GetKeyType(txType TXType, height Height, hash Hash) []byte {
+	return []byte(fmt.Sprintf(KeyType, txType, height, hash))
+}
+
+func GetKeyPrefixType(txType TXType) []byte {
+	return []byte(fmt.Sprintf(KeyPrefixType, txType))
+}
+
+func GetKeyIndexVersion() []byte {
+	return []byte(KeyIndexVersion)
+}
+
 func GetKeyMempoolHeight() []byte {
 	return []byte(KeyMempoolHeight)
 }
@@ -21,3 +59,21 @@ func GetKeyMempoolHeight() []byte {
 func GetKeyMempoolTX(hash Hash) []byte {
 	return []byte(fmt.Sprintf(KeyMempoolTX, hash))
 }
+
+func signerHash(pub PubKey) Hash {
+	return crypto.NewSHA256(pub.Bytes()).Bytes()
+}
+
+func parseKeyIndexHeight(key []byte) (Height, bool) {
+	// chain.txs.signer[SIGNER][HEIGHT][HASH]
+	// chain.txs.type[TYPE][HEIGHT][HASH]
+	parts := strings.Split(string(key), "][")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	num, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return Height(num), true
+}
@@ -0,0 +1,32 @@
+package kernel
+
+import (
+	"bytes"
+
+	"github.com/number571/go-peer/encoding"
+)
+
+const (
+	locationSize = 16 // height + position
+)
+
+func LoadLocation(data []byte) (Location, bool) {
+	if len(data) != locationSize {
+		return Location{}, false
+	}
+
+	return Location{
+		Height:   Height(encoding.BytesToUint64(data[:8])),
+		Position: encoding.BytesToUint64(data[8:]),
+	}, true
+}
+
+func (loc Location) Bytes() []byte {
+	return bytes.Join(
+		[][]byte{
+			encoding.Uint64ToBytes(uint64(loc.Height)),
+			encoding.Uint64ToBytes(loc.Position),
+		},
+		[]byte{},
+	)
+}
@@ -1,38 +1,178 @@
 package kernel
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"sync"
-
-	"github.com/number571/go-peer/encoding"
+	"time"
 )
 
 var (
 	_ Mempool = &MempoolT{}
 )
 
+var (
+	ErrTXInvalid   = errors.New("mempool: tx is invalid")
+	ErrTXExists    = errors.New("mempool: tx already exists")
+	ErrMempoolFull = errors.New("mempool: mempool is full")
+	ErrSenderQuota = errors.New("mempool: sender quota exceeded")
+	ErrTXRejected  = errors.New("mempool: tx rejected by application")
+)
+
 type MempoolT struct {
-	mtx sync.Mutex
-	ptr KeyValueDB
+	mtx      sync.Mutex
+	ptr      KeyValueDB
+	events   *eventsT
+	app      Application
+	policy   Policy
+	eviction Eviction
+	seq      uint64
+	index    map[string]MempoolEntry
+	senders  map[string]uint64
 }
 
-func (mempool *MempoolT) Height() Height {
-	data := mempool.ptr.Get(GetKeyMempoolHeight())
-	if data == nil {
-		panic("mempool: height undefined")
+type mempoolJSON struct {
+	TX   []byte `json:"tx"`
+	Seq  uint64 `json:"seq"`
+	Time int64  `json:"time"`
+}
+
+// Open mempool and rebuild in-memory index from database.
+// Size of mempool is the size of the index.
+func newMempool(db KeyValueDB, events *eventsT) *MempoolT {
+	mempool := &MempoolT{
+		ptr:      db,
+		events:   events,
+		policy:   NewFIFOPolicy(),
+		eviction: EvictReject,
+		index:    make(map[string]MempoolEntry),
+		senders:  make(map[string]uint64),
+	}
+
+	mempool.load()
+
+	for _, entry := range mempool.index {
+		if entry.Seq >= mempool.seq {
+			mempool.seq = entry.Seq + 1
+		}
 	}
-	return Height(encoding.BytesToUint64(data))
+
+	return mempool
+}
+
+func (mempool *MempoolT) SetPolicy(policy Policy) {
+	mempool.mtx.Lock()
+	defer mempool.mtx.Unlock()
+
+	mempool.policy = policy
+}
+
+func (mempool *MempoolT) setApplication(app Application) {
+	mempool.mtx.Lock()
+	defer mempool.mtx.Unlock()
+
+	mempool.app = app
+}
+
+func (mempool *MempoolT) SetEviction(eviction Eviction) {
+	mempool.mtx.Lock()
+	defer mempool.mtx.Unlock()
+
+	mempool.eviction = eviction
+}
+
+// Number of pending transactions signed by the public key.
+func (mempool *MempoolT) Pending(pub PubKey) uint64 {
+	mempool.mtx.Lock()
+	defer mempool.mtx.Unlock()
+
+	return mempool.senders[pub.Address()]
+}
+
+// Entries in policy order, limited by PageSize.
+func (mempool *MempoolT) List(offset, limit uint64) []MempoolEntry {
+	return mempool.list(nil, offset, limit)
+}
+
+// Entries of the signer in policy order, limited by PageSize.
+func (mempool *MempoolT) ListBySigner(pub PubKey, offset, limit uint64) []MempoolEntry {
+	return mempool.list(pub, offset, limit)
+}
+
+func (mempool *MempoolT) Stats() MempoolStats {
+	mempool.mtx.Lock()
+	defer mempool.mtx.Unlock()
+
+	stats := MempoolStats{
+		Count:   uint64(len(mempool.index)),
+		Senders: uint64(len(mempool.senders)),
+	}
+
+	first := true
+	for _, entry := range mempool.index {
+		stats.Bytes += uint64(len(entry.TX.Bytes()))
+
+		if first || entry.Time < stats.Oldest {
+			stats.Oldest = entry.Time
+		}
+		if first || entry.Time > stats.Newest {
+			stats.Newest = entry.Time
+		}
+		if first || entry.TX.Fee() < stats.MinFee {
+			stats.MinFee = entry.TX.Fee()
+		}
+		if first || entry.TX.Fee() > stats.MaxFee {
+			stats.MaxFee = entry.TX.Fee()
+		}
+
+		first = false
+	}
+
+	return stats
+}
+
+// Number of transactions, derived from the entries.
+func (mempool *MempoolT) Height() Height {
+	mempool.mtx.Lock()
+	defer mempool.mtx.Unlock()
+
+	return mempool.height()
+}
+
+// Reconcile in-memory index with database. With repair corrupt
+// entries are deleted, lost entries are restored, legacy counter
+// is removed.
+func (mempool *MempoolT) Check(repair bool) []Issue {
+	mempool.mtx.Lock()
+	defer mempool.mtx.Unlock()
+
+	return mempool.check(repair)
 }
 
 func (mempool *MempoolT) TX(hash Hash) Transaction {
 	data := mempool.ptr.Get(GetKeyMempoolTX(hash))
-	return LoadTransaction(data)
+	entry, ok := loadMempoolEntry(data)
+	if !ok {
+		return nil
+	}
+	return entry.TX
+}
+
+func (mempool *MempoolT) Entry(hash Hash) (MempoolEntry, bool) {
+	mempool.mtx.Lock()
+	defer mempool.mtx.Unlock()
+
+	entry, ok := mempool.index[string(hash)]
+	return entry, ok
 }
 
 func (mempool *MempoolT) Delete(hash Hash) {
 	mempool.mtx.Lock()
 	defer mempool.mtx.Unlock()
 
-	mempool.deleteTX(hash)
+	mempool.removeTX(hash)
 }
 
 func (mempool *MempoolT) Clear() {
@@ -43,85 +183,343 @@ func (mempool *MempoolT) Clear() {
 	defer iter.Close()
 
 	for iter.Next() {
-		txBytes := iter.Value()
-
-		tx := LoadTransaction(txBytes)
-		if tx == nil {
-			panic("mempool: tx is nil")
+		entry, ok := loadMempoolEntry(iter.Value())
+		if !ok {
+			mempool.ptr.Del(copyBytes(iter.Key()))
+			continue
 		}
 
-		mempool.deleteTX(tx.Hash())
+		mempool.removeTX(entry.TX.Hash())
 	}
 }
 
-func (mempool *MempoolT) Push(tx Transaction) {
+// Returns ErrMempoolFull if the mempool is full and
+// the eviction policy does not free space for the transaction.
+func (mempool *MempoolT) Push(tx Transaction) error {
 	mempool.mtx.Lock()
 	defer mempool.mtx.Unlock()
 
-	var (
-		hash      = tx.Hash()
-		newHeight = uint64(mempool.Height() + 1)
-	)
+	return mempool.push(tx, true)
+}
 
-	if newHeight > MempoolSize {
-		return
+// Transaction removed from block by merge was accepted before,
+// it is queued again above the limits so that it is not lost.
+func (mempool *MempoolT) restore(tx Transaction) error {
+	mempool.mtx.Lock()
+	defer mempool.mtx.Unlock()
+
+	return mempool.push(tx, false)
+}
+
+func (mempool *MempoolT) push(tx Transaction, limits bool) error {
+	if tx == nil || !tx.IsValid() {
+		return ErrTXInvalid
 	}
 
+	hash := tx.Hash()
 	if mempool.TX(hash) != nil {
-		return
+		return ErrTXExists
 	}
 
-	mempool.ptr.Set(GetKeyMempoolHeight(), encoding.Uint64ToBytes(newHeight))
-	mempool.ptr.Set(GetKeyMempoolTX(hash), tx.Bytes())
+	if limits && mempool.senders[senderOf(tx)] >= SenderSize {
+		return ErrSenderQuota
+	}
+
+	if limits && mempool.app != nil && mempool.app.CheckTx(tx) != nil {
+		return ErrTXRejected
+	}
+
+	entry := MempoolEntry{
+		TX:   tx,
+		Seq:  mempool.seq,
+		Time: time.Now().UnixNano(),
+	}
+
+	if limits && uint64(mempool.height()+1) > MempoolSize {
+		evicted, ok := mempool.evictFor(entry)
+		if !ok {
+			return ErrMempoolFull
+		}
+		mempool.deleteTX(evicted.TX.Hash())
+
+		mempool.events.publish(Event{
+			Kind: EventMempoolEvict,
+			Hash: evicted.TX.Hash(),
+		})
+	}
+
+	mempool.seq++
+
+	mempool.ptr.Set(GetKeyMempoolTX(hash), mempoolEntryBytes(entry))
+	mempool.index[string(hash)] = entry
+	mempool.senders[senderOf(tx)]++
+
+	mempool.events.publish(Event{
+		Kind: EventMempoolAdded,
+		Hash: hash,
+	})
+
+	return nil
 }
 
 func (mempool *MempoolT) Pop() []Transaction {
 	mempool.mtx.Lock()
 	defer mempool.mtx.Unlock()
 
-	if mempool.Height() < TXsSize {
+	if mempool.height() < TXsSize {
+		return nil
+	}
+
+	var entries []MempoolEntry
+	for _, entry := range mempool.index {
+		entries = append(entries, entry)
+	}
+
+	entries = mempool.policy.Order(entries)
+	if len(entries) < TXsSize {
+		return nil
+	}
+
+	var txs []Transaction
+	for _, entry := range entries[:TXsSize] {
+		txs = append(txs, entry.TX)
+	}
+
+	for _, tx := range txs {
+		mempool.removeTX(tx.Hash())
+	}
+
+	return txs
+}
+
+func (mempool *MempoolT) list(pub PubKey, offset, limit uint64) []MempoolEntry {
+	mempool.mtx.Lock()
+	defer mempool.mtx.Unlock()
+
+	if limit == 0 || limit > PageSize {
+		limit = PageSize
+	}
+
+	var entries []MempoolEntry
+	for _, entry := range mempool.index {
+		if pub != nil && senderOf(entry.TX) != pub.Address() {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	entries = mempool.policy.Order(entries)
+	if offset >= uint64(len(entries)) {
 		return nil
 	}
 
+	entries = entries[offset:]
+	if uint64(len(entries)) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries
+}
+
+// Select entry to evict for the new entry.
+func (mempool *MempoolT) evictFor(entry MempoolEntry) (MempoolEntry, bool) {
+	var entries []MempoolEntry
+	for _, e := range mempool.index {
+		entries = append(entries, e)
+	}
+
+	if len(entries) == 0 {
+		return MempoolEntry{}, false
+	}
+
+	switch mempool.eviction {
+	case EvictOldest:
+		sortBySeq(entries)
+		return entries[0], true
+	case EvictLowest:
+		entries = mempool.policy.Order(append(entries, entry))
+		lowest := entries[len(entries)-1]
+		if bytes.Equal(lowest.TX.Hash(), entry.TX.Hash()) {
+			return MempoolEntry{}, false
+		}
+		return lowest, true
+	default:
+		return MempoolEntry{}, false
+	}
+}
+
+func (mempool *MempoolT) height() Height {
+	return Height(len(mempool.index))
+}
+
+// Index is built from stored entries without changes of database,
+// corrupt entries and legacy counter are left to check.
+func (mempool *MempoolT) load() {
+	iter := mempool.ptr.Iter([]byte(KeyMempoolPrefixTX))
+	defer iter.Close()
+
+	for iter.Next() {
+		entry, ok := loadMempoolEntry(iter.Value())
+		if !ok {
+			continue
+		}
+		mempool.index[string(entry.TX.Hash())] = entry
+		mempool.senders[senderOf(entry.TX)]++
+	}
+}
+
+func (mempool *MempoolT) check(repair bool) []Issue {
 	var (
-		txs   []Transaction
-		count uint
+		issues []Issue
+		stored = make(map[string]bool)
 	)
 
 	iter := mempool.ptr.Iter([]byte(KeyMempoolPrefixTX))
 	defer iter.Close()
 
-	for count = 0; iter.Next() && count < TXsSize; count++ {
-		txBytes := iter.Value()
+	for iter.Next() {
+		entry, ok := loadMempoolEntry(iter.Value())
+		if !ok {
+			hash, _ := parseKeyHash(iter.Key(), KeyMempoolPrefixTX)
+			issues = append(issues, Issue{
+				Kind:  IssueMempoolCorrupt,
+				Hash:  fmt.Sprintf("%X", hash),
+				Fixed: repair,
+			})
+			if repair {
+				mempool.ptr.Del(copyBytes(iter.Key()))
+				if _, ok := mempool.index[string(hash)]; ok {
+					mempool.removeIndex(hash)
+				}
+			}
+			continue
+		}
+
+		hash := entry.TX.Hash()
+		stored[string(hash)] = true
 
-		tx := LoadTransaction(txBytes)
-		if tx == nil {
-			return nil
+		if _, ok := mempool.index[string(hash)]; ok {
+			continue
 		}
 
-		txs = append(txs, tx)
+		issues = append(issues, Issue{
+			Kind:  IssueMempoolIndex,
+			Hash:  fmt.Sprintf("%X", hash),
+			Fixed: repair,
+		})
+		if repair {
+			mempool.index[string(hash)] = entry
+			mempool.senders[senderOf(entry.TX)]++
+		}
 	}
 
-	if count != TXsSize {
-		return nil
+	for key, entry := range mempool.index {
+		if stored[key] {
+			continue
+		}
+		issues = append(issues, Issue{
+			Kind:  IssueMempoolIndex,
+			Hash:  fmt.Sprintf("%X", entry.TX.Hash()),
+			Fixed: repair,
+		})
+		if repair {
+			mempool.removeIndex(entry.TX.Hash())
+		}
 	}
 
-	for _, tx := range txs {
-		mempool.deleteTX(tx.Hash())
+	if mempool.ptr.Get(GetKeyMempoolHeight()) != nil {
+		issues = append(issues, Issue{
+			Kind:   IssueMempoolHeight,
+			Height: mempool.height(),
+			Fixed:  repair,
+		})
+		if repair {
+			mempool.ptr.Del(GetKeyMempoolHeight())
+		}
 	}
 
-	return txs
+	return issues
 }
 
-func (mempool *MempoolT) deleteTX(hash Hash) {
-	var (
-		newHeight = uint64(mempool.Height() - 1)
-	)
+// Delete transaction and notify subscribers.
+func (mempool *MempoolT) removeTX(hash Hash) {
+	if _, ok := mempool.index[string(hash)]; !ok {
+		return
+	}
 
-	if mempool.TX(hash) == nil {
+	mempool.deleteTX(hash)
+	mempool.events.publish(Event{
+		Kind: EventMempoolRemove,
+		Hash: hash,
+	})
+}
+
+func (mempool *MempoolT) deleteTX(hash Hash) {
+	if _, ok := mempool.index[string(hash)]; !ok {
 		return
 	}
 
-	mempool.ptr.Set(GetKeyMempoolHeight(), encoding.Uint64ToBytes(newHeight))
 	mempool.ptr.Del(GetKeyMempoolTX(hash))
+	mempool.removeIndex(hash)
+}
+
+func (mempool *MempoolT) removeIndex(hash Hash) {
+
+	if entry, ok := mempool.index[string(hash)]; ok {
+		mempool.decSender(entry.TX)
+		delete(mempool.index, string(hash))
+	}
+}
+
+func (mempool *MempoolT) decSender(tx Transaction) {
+	sender := senderOf(tx)
+	if mempool.senders[sender] <= 1 {
+		delete(mempool.senders, sender)
+		return
+	}
+	mempool.senders[sender]--
+}
+
+func senderOf(tx Transaction) string {
+	return tx.Validator().Address()
+}
+
+func mempoolEntryBytes(entry MempoolEntry) []byte {
+	entryBytes, err := json.Marshal(&mempoolJSON{
+		TX:   entry.TX.Bytes(),
+		Seq:  entry.Seq,
+		Time: entry.Time,
+	})
+	if err != nil {
+		return nil
+	}
+	return entryBytes
+}
+
+// Entries written before the index was introduced are plain transactions.
+func loadMempoolEntry(data []byte) (MempoolEntry, bool) {
+	entryConv := new(mempoolJSON)
+	err := json.Unmarshal(data, entryConv)
+	if err != nil {
+		return MempoolEntry{}, false
+	}
+
+	if entryConv.TX == nil {
+		tx := LoadTransaction(data)
+		if tx == nil {
+			return MempoolEntry{}, false
+		}
+		return MempoolEntry{TX: tx}, true
+	}
+
+	tx := LoadTransaction(entryConv.TX)
+	if tx == nil {
+		return MempoolEntry{}, false
+	}
+
+	return MempoolEntry{
+		TX:   tx,
+		Seq:  entryConv.Seq,
+		Time: entryConv.Time,
+	}, true
 }
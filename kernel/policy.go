@@ -0,0 +1,84 @@
+package kernel
+
+import (
+	"sort"
+)
+
+var (
+	_ Policy = &FIFOPolicyT{}
+	_ Policy = &FeePolicyT{}
+	_ Policy = &FairPolicyT{}
+)
+
+// Transactions in order of arrival.
+type FIFOPolicyT struct{}
+
+// Transactions with greater fee first, equal fees in order of arrival.
+type FeePolicyT struct{}
+
+// Senders take turns, transactions of one sender in order of arrival.
+type FairPolicyT struct{}
+
+func NewFIFOPolicy() Policy {
+	return &FIFOPolicyT{}
+}
+
+func NewFeePolicy() Policy {
+	return &FeePolicyT{}
+}
+
+func NewFairPolicy() Policy {
+	return &FairPolicyT{}
+}
+
+func (policy *FIFOPolicyT) Order(entries []MempoolEntry) []MempoolEntry {
+	sortBySeq(entries)
+	return entries
+}
+
+func (policy *FeePolicyT) Order(entries []MempoolEntry) []MempoolEntry {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].TX.Fee() != entries[j].TX.Fee() {
+			return entries[i].TX.Fee() > entries[j].TX.Fee()
+		}
+		return entries[i].Seq < entries[j].Seq
+	})
+	return entries
+}
+
+func (policy *FairPolicyT) Order(entries []MempoolEntry) []MempoolEntry {
+	sortBySeq(entries)
+
+	var (
+		senders []string
+		queues  = make(map[string][]MempoolEntry)
+		result  = make([]MempoolEntry, 0, len(entries))
+	)
+
+	for _, entry := range entries {
+		sender := senderOf(entry.TX)
+		if _, ok := queues[sender]; !ok {
+			senders = append(senders, sender)
+		}
+		queues[sender] = append(queues[sender], entry)
+	}
+
+	for len(result) != len(entries) {
+		for _, sender := range senders {
+			queue := queues[sender]
+			if len(queue) == 0 {
+				continue
+			}
+			result = append(result, queue[0])
+			queues[sender] = queue[1:]
+		}
+	}
+
+	return result
+}
+
+func sortBySeq(entries []MempoolEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Seq < entries[j].Seq
+	})
+}
@@ -0,0 +1,104 @@
+package kernel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	_ Registry = &RegistryT{}
+)
+
+var (
+	ErrTypeExists     = errors.New("registry: type already registered")
+	ErrTypeUnknown    = errors.New("registry: type unknown")
+	ErrPayloadInvalid = errors.New("registry: payload invalid")
+)
+
+// Schemas of transaction types registered by applications.
+// Transactions without type are accepted unless raw schema is set.
+type RegistryT struct {
+	mtx     sync.RWMutex
+	schemas map[TXType]Schema
+}
+
+func NewRegistry() Registry {
+	return &RegistryT{
+		schemas: make(map[TXType]Schema),
+	}
+}
+
+func (registry *RegistryT) Register(txType TXType, schema Schema) error {
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+
+	if _, ok := registry.schemas[txType]; ok {
+		return ErrTypeExists
+	}
+
+	registry.schemas[txType] = schema
+	return nil
+}
+
+func (registry *RegistryT) Schema(txType TXType) (Schema, bool) {
+	registry.mtx.RLock()
+	defer registry.mtx.RUnlock()
+
+	schema, ok := registry.schemas[txType]
+	return schema, ok
+}
+
+func (registry *RegistryT) Check(tx Transaction) error {
+	_, err := registry.decode(tx)
+	return err
+}
+
+// Name of type with decoded payload as fmt.Stringer or in JSON,
+// payload in hex if it has no schema or is invalid.
+func (registry *RegistryT) Render(tx Transaction) string {
+	name := fmt.Sprintf("type(%d)", tx.Type())
+	if schema, ok := registry.Schema(tx.Type()); ok {
+		name = schema.Name()
+	} else if tx.Type() == TXTypeRaw {
+		name = "raw"
+	}
+
+	value, err := registry.decode(tx)
+	if err != nil || value == nil {
+		return fmt.Sprintf("%s %X", name, tx.PayLoad())
+	}
+
+	if stringer, ok := value.(fmt.Stringer); ok {
+		return fmt.Sprintf("%s %s", name, stringer.String())
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%s %X", name, tx.PayLoad())
+	}
+
+	return fmt.Sprintf("%s %s", name, data)
+}
+
+func (registry *RegistryT) decode(tx Transaction) (interface{}, error) {
+	schema, ok := registry.Schema(tx.Type())
+	if !ok {
+		if tx.Type() == TXTypeRaw {
+			return nil, nil
+		}
+		return nil, ErrTypeUnknown
+	}
+
+	value, err := schema.Decode(tx.PayLoad())
+	if err != nil {
+		return nil, ErrPayloadInvalid
+	}
+
+	if err := schema.Validate(value); err != nil {
+		return nil, ErrPayloadInvalid
+	}
+
+	return value, nil
+}
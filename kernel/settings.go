@@ -1,21 +1,56 @@
 package kernel
 
+const (
+	EvictReject Eviction = iota // reject new transaction
+	EvictOldest                 // evict transaction with earliest arrival
+	EvictLowest                 // evict last transaction in policy order
+)
+
+const (
+	TXTypeRaw TXType = 0 // payload without schema
+)
+
 const (
 	KeySize     = 1024 // num bits
 	MempoolSize = 1000 // max num txs in mempool
+	SenderSize  = 64   // max num txs of one validator in mempool
 
+	IndexVersion = 2 // version of block hash, location, signer and type indexes
+
+	PageSize    = 256  // max num entries in query result
 	TXsSize     = 32   // num txs in block
 	PayloadSize = 1024 // num bytes in tx.payload
 
+	ArchiveMagic     = "union-bc.archive.v1\n"
+	ArchiveBlockSize = (4 << 20) // 4MiB
+
 	BlocksPath  = "blocks.db"
 	TXsPath     = "txs.db"
 	MempoolPath = "mempool.db"
 
-	KeyHeight = "chain.blocks.height"
-	KeyBlock  = "chain.blocks.block[%d]"
-	KeyTX     = "chain.txs.tx[%X]"
+	KeyHeight    = "chain.blocks.height"
+	KeyBlock     = "chain.blocks.block[%d]"
+	KeyBlockHash = "chain.blocks.hash[%X]"
+	KeyRoot      = "chain.blocks.root[%d]"
+	KeyTX        = "chain.txs.tx[%X]"
+
+	KeyLocation = "chain.txs.location[%X]"
+	KeySigner   = "chain.txs.signer[%X][%020d][%X]"
+	KeyType     = "chain.txs.type[%010d][%020d][%X]"
+
+	KeyIndexVersion = "chain.txs.index.version"
+
+	KeyPrefixBlock     = "chain.blocks.block["
+	KeyPrefixHash      = "chain.blocks.hash["
+	KeyPrefixRoot      = "chain.blocks.root["
+	KeyPrefixTX        = "chain.txs.tx["
+	KeyPrefixLocation  = "chain.txs.location["
+	KeyPrefixSigner    = "chain.txs.signer[%X]["
+	KeyPrefixSignerAll = "chain.txs.signer["
+	KeyPrefixType      = "chain.txs.type[%010d]["
+	KeyPrefixTypeAll   = "chain.txs.type["
 
-	KeyMempoolHeight   = "chain.mempool.height"
+	KeyMempoolHeight   = "chain.mempool.height" // legacy, removed by repair
 	KeyMempoolTX       = "chain.mempool.tx[%X]"
 	KeyMempoolPrefixTX = "chain.mempool.tx["
 )
@@ -2,10 +2,12 @@ package kernel
 
 import (
 	"bytes"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 
 	"github.com/number571/go-peer/crypto"
+	"github.com/number571/go-peer/encoding"
 )
 
 var (
@@ -13,20 +15,34 @@ var (
 )
 
 type TransactionT struct {
+	txType    TXType
 	payLoad   []byte
+	fee       uint64
 	hash      []byte
 	sign      []byte
 	validator crypto.PubKey
 }
 
 type txJSON struct {
+	Type      TXType `json:"type,omitempty"`
 	PayLoad   []byte `json:"pay_load"`
+	Fee       uint64 `json:"fee,omitempty"`
 	Hash      []byte `json:"hash"`
 	Sign      []byte `json:"sign"`
 	Validator []byte `json:"validator"`
 }
 
 func NewTransaction(priv PrivKey, payLoad []byte) Transaction {
+	return NewTransactionWithFee(priv, payLoad, 0)
+}
+
+// Fee is used by mempool policies to order transactions.
+func NewTransactionWithFee(priv PrivKey, payLoad []byte, fee uint64) Transaction {
+	return NewTypedTransaction(priv, TXTypeRaw, payLoad, fee)
+}
+
+// Payload of typed transaction is checked by schema of its type.
+func NewTypedTransaction(priv PrivKey, txType TXType, payLoad []byte, fee uint64) Transaction {
 	if priv == nil {
 		return nil
 	}
@@ -40,7 +56,9 @@ func NewTransaction(priv PrivKey, payLoad []byte) Transaction {
 	}
 
 	tx := &TransactionT{
+		txType:    txType,
 		payLoad:   payLoad,
+		fee:       fee,
 		validator: priv.PubKey(),
 	}
 
@@ -58,7 +76,9 @@ func LoadTransaction(txbytes []byte) Transaction {
 	}
 
 	tx := &TransactionT{
+		txType:    txConv.Type,
 		payLoad:   txConv.PayLoad,
+		fee:       txConv.Fee,
 		hash:      txConv.Hash,
 		sign:      txConv.Sign,
 		validator: crypto.LoadPubKey(txConv.Validator),
@@ -71,10 +91,26 @@ func LoadTransaction(txbytes []byte) Transaction {
 	return tx
 }
 
+// Load public key of validator, nil if bytes are not a public key.
+func LoadPubKey(pbytes []byte) PubKey {
+	if _, err := x509.ParsePKCS1PublicKey(pbytes); err != nil {
+		return nil
+	}
+	return crypto.LoadPubKey(pbytes)
+}
+
+func (tx *TransactionT) Type() TXType {
+	return tx.txType
+}
+
 func (tx *TransactionT) PayLoad() []byte {
 	return tx.payLoad
 }
 
+func (tx *TransactionT) Fee() uint64 {
+	return tx.fee
+}
+
 func (tx *TransactionT) Hash() Hash {
 	return tx.hash
 }
@@ -89,7 +125,9 @@ func (tx *TransactionT) Validator() PubKey {
 
 func (tx *TransactionT) Bytes() []byte {
 	txConv := &txJSON{
+		Type:      tx.Type(),
 		PayLoad:   tx.PayLoad(),
+		Fee:       tx.Fee(),
 		Hash:      tx.Hash(),
 		Sign:      tx.Sign(),
 		Validator: tx.Validator().Bytes(),
@@ -124,11 +162,34 @@ func (tx *TransactionT) IsValid() bool {
 }
 
 func (tx *TransactionT) newHash() Hash {
-	return crypto.NewSHA256(bytes.Join(
+	hash := crypto.NewSHA256(bytes.Join(
 		[][]byte{
 			tx.Validator().Bytes(),
 			tx.PayLoad(),
 		},
 		[]byte{},
 	)).Bytes()
+
+	// Transactions without fee and type keep the original hash.
+	if tx.fee != 0 {
+		hash = crypto.NewSHA256(bytes.Join(
+			[][]byte{
+				hash,
+				encoding.Uint64ToBytes(tx.fee),
+			},
+			[]byte{},
+		)).Bytes()
+	}
+
+	if tx.txType != TXTypeRaw {
+		hash = crypto.NewSHA256(bytes.Join(
+			[][]byte{
+				hash,
+				encoding.Uint64ToBytes(uint64(tx.txType)),
+			},
+			[]byte{},
+		)).Bytes()
+	}
+
+	return hash
 }
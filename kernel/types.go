@@ -7,10 +7,23 @@ import (
 type Height uint64
 type Hash []byte
 type Sign []byte
+type TXType uint32
 
 type PrivKey crypto.PrivKey
 type PubKey crypto.PubKey
 
+// Location of transaction in the chain.
+type TXEntry struct {
+	Height Height `json:"height"`
+	Hash   Hash   `json:"hash"`
+}
+
+// Position of transaction in the block at height.
+type Location struct {
+	Height   Height `json:"height"`
+	Position uint64 `json:"position"`
+}
+
 type Wrapper interface {
 	Bytes() []byte
 	String() string
@@ -43,32 +56,129 @@ type KeyValueDB interface {
 	Close()
 }
 
+type ArchiveWriter interface {
+	Write(Height, Block) error
+}
+
+type ArchiveReader interface {
+	Read() (Height, Block, error)
+}
+
+// Transaction in mempool with its arrival order.
+type MempoolEntry struct {
+	TX   Transaction
+	Seq  uint64
+	Time int64
+}
+
+// Summary of mempool, times in unix nanoseconds.
+type MempoolStats struct {
+	Count   uint64 `json:"count"`
+	Bytes   uint64 `json:"bytes"`
+	Senders uint64 `json:"senders"`
+	Oldest  int64  `json:"oldest"`
+	Newest  int64  `json:"newest"`
+	MinFee  uint64 `json:"min_fee"`
+	MaxFee  uint64 `json:"max_fee"`
+}
+
+// Action of full mempool on push.
+type Eviction uint8
+
+// Order of mempool transactions for blocks proposed by the node.
+// Merged blocks take transactions by fee and hash on every node,
+// so FIFO and fair orders apply only to local proposals.
+type Policy interface {
+	Order([]MempoolEntry) []MempoolEntry
+}
+
+// Deterministic state machine driven by the chain. Blocks are
+// delivered in order of height, transactions in order of block.
+type Application interface {
+	// Last committed height, false if nothing is committed.
+	Info() (Height, bool)
+
+	CheckTx(Transaction) error
+	DeliverTx(Transaction) error
+
+	// Commit delivered transactions as state of height, returns state root.
+	Commit(Height) Hash
+
+	// Restore state committed at height or drop all state.
+	Rollback(Height)
+	Reset()
+}
+
+// Decoder and validator of payloads of one transaction type.
+type Schema interface {
+	Name() string
+	Decode([]byte) (interface{}, error)
+	Validate(interface{}) error
+}
+
+type Registry interface {
+	Register(TXType, Schema) error
+	Schema(TXType) (Schema, bool)
+
+	// Decode and validate payload by schema of transaction type.
+	Check(Transaction) error
+	Render(Transaction) string
+}
+
+type Subscription interface {
+	Events() <-chan Event
+	Close()
+}
+
 type Mempool interface {
 	Height() Height
 	TX(Hash) Transaction
+	Entry(Hash) (MempoolEntry, bool)
+	Pending(PubKey) uint64
+	Stats() MempoolStats
 
-	Push(Transaction)
+	List(uint64, uint64) []MempoolEntry
+	ListBySigner(PubKey, uint64, uint64) []MempoolEntry
+
+	SetPolicy(Policy)
+	SetEviction(Eviction)
+
+	Push(Transaction) error
 	Pop() []Transaction
 
 	Delete(Hash)
 	Clear()
+	Check(bool) []Issue
 }
 
 type Chain interface {
 	Accept(Block) bool
+	Propose([]Transaction) Block
 	Merge(Height, []Transaction) bool
 	Rollback(uint64) bool
+	Verify(bool) []Issue
 
 	Height() Height
 	TX(Hash) Transaction
 	Block(Height) Block
+	BlockByHash(Hash) Block
+	BlockHeight(Hash) (Height, bool)
+	TXLocation(Hash) (Location, bool)
+	TXsBySigner(PubKey, uint64, uint64) []TXEntry
+	TXsByType(TXType, uint64, uint64) []TXEntry
+
+	SetApplication(Application)
+	StateRoot(Height) Hash
 
+	Subscribe(uint64) Subscription
+	MoveSubscriptions(Chain)
 	Mempool() Mempool
 	Close()
 }
 
 type Block interface {
 	PrevHash() Hash
+	StateRoot() Hash
 	Transactions() []Transaction
 
 	Wrapper
@@ -76,7 +186,9 @@ type Block interface {
 }
 
 type Transaction interface {
+	Type() TXType
 	PayLoad() []byte
+	Fee() uint64
 
 	Wrapper
 	Signifier
@@ -0,0 +1,361 @@
+package kernel
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/number571/go-peer/encoding"
+)
+
+const (
+	IssueHeightUndefined = "height_undefined"
+	IssueBlockMissing    = "block_missing"
+	IssueBlockCorrupt    = "block_corrupt"
+	IssuePrevHash        = "prev_hash_mismatch"
+	IssueBlockOrphan     = "block_orphan"
+	IssueHashMissing     = "block_hash_missing"
+	IssueHashOrphan      = "block_hash_orphan"
+	IssueTXMissing       = "tx_missing"
+	IssueTXCorrupt       = "tx_corrupt"
+	IssueTXOrphan        = "tx_orphan"
+	IssueLocation        = "location_mismatch"
+	IssueLocationOrphan  = "location_orphan"
+	IssueSignerMissing   = "signer_missing"
+	IssueSignerOrphan    = "signer_orphan"
+	IssueTypeMissing     = "type_missing"
+	IssueTypeOrphan      = "type_orphan"
+	IssueMempoolCorrupt  = "mempool_tx_corrupt"
+	IssueMempoolInChain  = "mempool_tx_in_chain"
+	IssueMempoolIndex    = "mempool_index_mismatch"
+	IssueMempoolHeight   = "mempool_height_legacy"
+)
+
+// Inconsistency found by Chain.Verify.
+type Issue struct {
+	Kind   string `json:"kind"`
+	Height Height `json:"height"`
+	Hash   string `json:"hash,omitempty"`
+	Fixed  bool   `json:"fixed"`
+}
+
+// Walk every block of the chain and cross-check blocks, txs and mempool.
+// With repair the chain is truncated to the last consistent block like
+// by Rollback, and the indexes are rebuilt from the remaining blocks.
+func (chain *ChainT) Verify(repair bool) []Issue {
+	chain.mtx.Lock()
+	defer chain.mtx.Unlock()
+
+	var (
+		issues   []Issue
+		height   Height
+		firstBad = Height(0)
+		hasBad   = false
+		blocks   []Block
+	)
+
+	data := chain.blocks.Get(GetKeyHeight())
+	if data != nil {
+		height = Height(encoding.BytesToUint64(data))
+	} else {
+		height = chain.lastContiguousHeight()
+		issues = append(issues, Issue{
+			Kind:   IssueHeightUndefined,
+			Height: height,
+			Fixed:  repair,
+		})
+		if repair {
+			chain.setHeight(height)
+			chain.rollbackState(height)
+		}
+	}
+
+	var prevBlock Block
+	for i := Height(0); i <= height; i++ {
+		kind := ""
+
+		data := chain.blocks.Get(GetKeyBlock(i))
+		block := LoadBlock(data)
+
+		switch {
+		case data == nil:
+			kind = IssueBlockMissing
+		case block == nil:
+			kind = IssueBlockCorrupt
+		case i != 0 && prevBlock != nil && !bytes.Equal(prevBlock.Hash(), block.PrevHash()):
+			kind = IssuePrevHash
+		}
+
+		prevBlock = block
+		if kind == "" {
+			if !hasBad {
+				blocks = append(blocks, block)
+			}
+			continue
+		}
+
+		if !hasBad {
+			hasBad = true
+			firstBad = i
+		}
+
+		issues = append(issues, Issue{
+			Kind:   kind,
+			Height: i,
+			Fixed:  repair && firstBad != 0,
+		})
+	}
+
+	truncated := repair && hasBad && firstBad != 0
+	if truncated {
+		height = firstBad - 1
+		chain.setHeight(height)
+		chain.rollbackState(height)
+	}
+
+	// Blocks stored above the current height.
+	iter := chain.blocks.Iter([]byte(KeyPrefixBlock))
+	for iter.Next() {
+		i, ok := parseKeyHeight(iter.Key(), KeyPrefixBlock)
+		if !ok || i <= height {
+			continue
+		}
+		issues = append(issues, Issue{
+			Kind:   IssueBlockOrphan,
+			Height: i,
+			Fixed:  repair,
+		})
+		if repair {
+			chain.blocks.Del(GetKeyBlock(i))
+		}
+	}
+	iter.Close()
+
+	// Blocks must be found by hash.
+	for i, block := range blocks {
+		height, ok := chain.getBlockHeight(block.Hash())
+		if ok && height == Height(i) {
+			continue
+		}
+		issues = append(issues, Issue{
+			Kind:   IssueHashMissing,
+			Height: Height(i),
+			Hash:   fmt.Sprintf("%X", block.Hash()),
+			Fixed:  repair,
+		})
+		if repair {
+			chain.blocks.Set(GetKeyBlockHash(block.Hash()), encoding.Uint64ToBytes(uint64(i)))
+		}
+	}
+
+	if !hasBad || truncated {
+		iter = chain.blocks.Iter([]byte(KeyPrefixHash))
+		for iter.Next() {
+			hash, _ := parseKeyHash(iter.Key(), KeyPrefixHash)
+			height, ok := chain.getBlockHeight(hash)
+			if ok && height < Height(len(blocks)) && bytes.Equal(blocks[height].Hash(), hash) {
+				continue
+			}
+			issues = append(issues, Issue{
+				Kind:   IssueHashOrphan,
+				Height: height,
+				Hash:   fmt.Sprintf("%X", hash),
+				Fixed:  repair,
+			})
+			if repair {
+				chain.blocks.Del(copyBytes(iter.Key()))
+			}
+		}
+		iter.Close()
+	}
+
+	// Transactions of the blocks must be in txs.db.
+	inChain := make(map[string]Height)
+	for i, block := range blocks {
+		for j, tx := range block.Transactions() {
+			inChain[string(tx.Hash())] = Height(i)
+
+			var (
+				data = chain.txs.Get(GetKeyTX(tx.Hash()))
+				loc  = Location{Height(i), uint64(j)}
+				kind = ""
+			)
+
+			txLoc, ok := chain.getLocation(tx.Hash())
+
+			switch {
+			case data == nil:
+				kind = IssueTXMissing
+			case !bytes.Equal(data, tx.Bytes()):
+				kind = IssueTXCorrupt
+			case !ok || txLoc != loc:
+				kind = IssueLocation
+			case chain.txs.Get(GetKeySigner(tx.Validator(), Height(i), tx.Hash())) == nil:
+				kind = IssueSignerMissing
+			case tx.Type() != TXTypeRaw && chain.txs.Get(GetKeyType(tx.Type(), Height(i), tx.Hash())) == nil:
+				kind = IssueTypeMissing
+			}
+
+			if kind == "" {
+				continue
+			}
+
+			issues = append(issues, Issue{
+				Kind:   kind,
+				Height: Height(i),
+				Hash:   fmt.Sprintf("%X", tx.Hash()),
+				Fixed:  repair,
+			})
+			if repair {
+				chain.setTX(loc, tx)
+			}
+		}
+	}
+
+	// Entries of txs.db without block. Skipped when the chain is broken
+	// and not truncated, because the tail blocks are unknown.
+	if !hasBad || truncated {
+		iter = chain.txs.Iter([]byte(KeyPrefixTX))
+		for iter.Next() {
+			hash, ok := parseKeyHash(iter.Key(), KeyPrefixTX)
+			if ok {
+				if _, ok := inChain[string(hash)]; ok {
+					continue
+				}
+			}
+			issues = append(issues, Issue{
+				Kind:  IssueTXOrphan,
+				Hash:  fmt.Sprintf("%X", hash),
+				Fixed: repair,
+			})
+			if repair {
+				chain.txs.Del(copyBytes(iter.Key()))
+			}
+		}
+		iter.Close()
+
+		iter = chain.txs.Iter([]byte(KeyPrefixLocation))
+		for iter.Next() {
+			hash, ok := parseKeyHash(iter.Key(), KeyPrefixLocation)
+			if ok {
+				if _, ok := inChain[string(hash)]; ok {
+					continue
+				}
+			}
+			issues = append(issues, Issue{
+				Kind:  IssueLocationOrphan,
+				Hash:  fmt.Sprintf("%X", hash),
+				Fixed: repair,
+			})
+			if repair {
+				chain.txs.Del(copyBytes(iter.Key()))
+			}
+		}
+		iter.Close()
+
+		iter = chain.txs.Iter([]byte(KeyPrefixSignerAll))
+		for iter.Next() {
+			height, ok := parseKeyIndexHeight(iter.Key())
+			if ok {
+				if i, ok := inChain[string(iter.Value())]; ok && i == height {
+					continue
+				}
+			}
+			issues = append(issues, Issue{
+				Kind:   IssueSignerOrphan,
+				Height: height,
+				Hash:   fmt.Sprintf("%X", iter.Value()),
+				Fixed:  repair,
+			})
+			if repair {
+				chain.txs.Del(copyBytes(iter.Key()))
+			}
+		}
+		iter.Close()
+
+		iter = chain.txs.Iter([]byte(KeyPrefixTypeAll))
+		for iter.Next() {
+			height, ok := parseKeyIndexHeight(iter.Key())
+			if ok {
+				if i, ok := inChain[string(iter.Value())]; ok && i == height {
+					continue
+				}
+			}
+			issues = append(issues, Issue{
+				Kind:   IssueTypeOrphan,
+				Height: height,
+				Hash:   fmt.Sprintf("%X", iter.Value()),
+				Fixed:  repair,
+			})
+			if repair {
+				chain.txs.Del(copyBytes(iter.Key()))
+			}
+		}
+		iter.Close()
+	}
+
+	issues = append(issues, chain.verifyMempool(inChain, repair)...)
+	return issues
+}
+
+func (chain *ChainT) verifyMempool(inChain map[string]Height, repair bool) []Issue {
+	mempool := chain.mempool
+
+	mempool.mtx.Lock()
+	defer mempool.mtx.Unlock()
+
+	issues := mempool.check(repair)
+
+	for _, entry := range mempool.index {
+		tx := entry.TX
+
+		height, ok := inChain[string(tx.Hash())]
+		if !ok {
+			continue
+		}
+
+		issues = append(issues, Issue{
+			Kind:   IssueMempoolInChain,
+			Height: height,
+			Hash:   fmt.Sprintf("%X", tx.Hash()),
+			Fixed:  repair,
+		})
+		if repair {
+			mempool.deleteTX(tx.Hash())
+		}
+	}
+
+	return issues
+}
+
+func (chain *ChainT) lastContiguousHeight() Height {
+	height := Height(0)
+	for chain.blocks.Get(GetKeyBlock(height+1)) != nil {
+		height++
+	}
+	return height
+}
+
+func parseKeyHeight(key []byte, prefix string) (Height, bool) {
+	str := strings.TrimSuffix(strings.TrimPrefix(string(key), prefix), "]")
+	num, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return Height(num), true
+}
+
+func parseKeyHash(key []byte, prefix string) (Hash, bool) {
+	str := strings.TrimSuffix(strings.TrimPrefix(string(key), prefix), "]")
+	hash, err := hex.DecodeString(str)
+	if err != nil {
+		return nil, false
+	}
+	return hash, true
+}
+
+func copyBytes(data []byte) []byte {
+	return append([]byte{}, data...)
+}
@@ -0,0 +1,237 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/number571/go-peer/encoding"
+	"github.com/number571/union-bc/kernel"
+)
+
+var (
+	_ KVStore = &KVStoreT{}
+)
+
+// Key-value state machine. Every committed height keeps
+// undo record with previous values of changed keys.
+type KVStoreT struct {
+	mtx     sync.Mutex
+	ptr     kernel.KeyValueDB
+	pending map[string]*[]byte
+}
+
+type undoEntry struct {
+	Key    []byte `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+	Exists bool   `json:"exists"`
+}
+
+func NewKVStore(path string) KVStore {
+	db := kernel.NewDB(path)
+	if db == nil {
+		return nil
+	}
+
+	store := &KVStoreT{
+		ptr:     db,
+		pending: make(map[string]*[]byte),
+	}
+
+	// State of older store is replayed by the chain.
+	data := db.Get([]byte(KeyVersion))
+	if len(data) != 8 || encoding.BytesToUint64(data) != StoreVersion {
+		store.Reset()
+		db.Set([]byte(KeyVersion), encoding.Uint64ToBytes(StoreVersion))
+	}
+
+	return store
+}
+
+func (store *KVStoreT) Info() (kernel.Height, bool) {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	return store.height()
+}
+
+func (store *KVStoreT) CheckTx(tx kernel.Transaction) error {
+	_, err := loadTXOperation(tx)
+	return err
+}
+
+func (store *KVStoreT) DeliverTx(tx kernel.Transaction) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	op, err := loadTXOperation(tx)
+	if err != nil {
+		return err
+	}
+
+	switch op.Op {
+	case OpSet:
+		value := nonNil(op.Value)
+		store.pending[string(op.Key)] = &value
+	case OpDelete:
+		store.pending[string(op.Key)] = nil
+	}
+
+	return nil
+}
+
+func (store *KVStoreT) Commit(height kernel.Height) kernel.Hash {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	undo := make([]undoEntry, 0, len(store.pending))
+	for key, value := range store.pending {
+		prev := store.ptr.Get(getKeyValue([]byte(key)))
+		undo = append(undo, undoEntry{
+			Key:    []byte(key),
+			Value:  prev,
+			Exists: prev != nil,
+		})
+
+		if value == nil {
+			store.ptr.Del(getKeyValue([]byte(key)))
+			store.treeUpdate([]byte(key), nil)
+			continue
+		}
+		store.ptr.Set(getKeyValue([]byte(key)), *value)
+		store.treeUpdate([]byte(key), *value)
+	}
+
+	undoBytes, err := json.Marshal(undo)
+	if err != nil {
+		panic(err)
+	}
+
+	store.ptr.Set(getKeyUndo(height), undoBytes)
+	store.ptr.Set([]byte(KeyHeight), encoding.Uint64ToBytes(uint64(height)))
+	store.pending = make(map[string]*[]byte)
+
+	return store.treeRoot()
+}
+
+func (store *KVStoreT) Rollback(height kernel.Height) {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	store.pending = make(map[string]*[]byte)
+
+	current, ok := store.height()
+	if !ok || current <= height {
+		return
+	}
+
+	for i := current; i > height; i-- {
+		undo := []undoEntry{}
+		if err := json.Unmarshal(store.ptr.Get(getKeyUndo(i)), &undo); err != nil {
+			panic("kvstore: undo record undefined")
+		}
+
+		for _, entry := range undo {
+			if !entry.Exists {
+				store.ptr.Del(getKeyValue(entry.Key))
+				store.treeUpdate(entry.Key, nil)
+				continue
+			}
+			value := nonNil(entry.Value)
+			store.ptr.Set(getKeyValue(entry.Key), value)
+			store.treeUpdate(entry.Key, value)
+		}
+
+		store.ptr.Del(getKeyUndo(i))
+	}
+
+	store.ptr.Set([]byte(KeyHeight), encoding.Uint64ToBytes(uint64(height)))
+}
+
+func (store *KVStoreT) Reset() {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	store.pending = make(map[string]*[]byte)
+
+	for _, prefix := range []string{KeyPrefixValue, KeyPrefixUndo, KeyPrefixNode} {
+		keys := [][]byte{}
+
+		iter := store.ptr.Iter([]byte(prefix))
+		for iter.Next() {
+			keys = append(keys, copyBytes(iter.Key()))
+		}
+		iter.Close()
+
+		for _, key := range keys {
+			store.ptr.Del(key)
+		}
+	}
+
+	store.ptr.Del([]byte(KeyHeight))
+}
+
+func (store *KVStoreT) Query(key []byte) (Proof, bool) {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	height, ok := store.height()
+	if !ok {
+		return Proof{}, false
+	}
+
+	value := store.ptr.Get(getKeyValue(key))
+	if value == nil {
+		return Proof{}, false
+	}
+
+	bitmap, path := store.treeProof(key)
+	return Proof{
+		Height: height,
+		Key:    copyBytes(key),
+		Value:  value,
+		Bitmap: bitmap,
+		Path:   path,
+	}, true
+}
+
+func (store *KVStoreT) Close() {
+	store.ptr.Close()
+}
+
+func (store *KVStoreT) height() (kernel.Height, bool) {
+	data := store.ptr.Get([]byte(KeyHeight))
+	if len(data) != 8 {
+		return 0, false
+	}
+	return kernel.Height(encoding.BytesToUint64(data)), true
+}
+
+func loadTXOperation(tx kernel.Transaction) (Operation, error) {
+	if tx.Type() != TXTypeKV {
+		return Operation{}, ErrOpInvalid
+	}
+	return LoadOperation(tx.PayLoad())
+}
+
+func getKeyValue(key []byte) []byte {
+	return []byte(fmt.Sprintf(KeyValue, key))
+}
+
+func getKeyUndo(height kernel.Height) []byte {
+	return []byte(fmt.Sprintf(KeyUndo, height))
+}
+
+// Empty value is present in state, nil value is absent.
+func nonNil(value []byte) []byte {
+	if value == nil {
+		return []byte{}
+	}
+	return value
+}
+
+func copyBytes(data []byte) []byte {
+	result := make([]byte, len(data))
+	copy(result, data)
+	return result
+}
@@ -0,0 +1,61 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var (
+	ErrOpInvalid = errors.New("kvstore: invalid operation")
+)
+
+func NewSet(key, value []byte) []byte {
+	return newOperation(Operation{Op: OpSet, Key: key, Value: value})
+}
+
+func NewDelete(key []byte) []byte {
+	return newOperation(Operation{Op: OpDelete, Key: key})
+}
+
+func LoadOperation(payLoad []byte) (Operation, error) {
+	var op Operation
+
+	if err := json.Unmarshal(payLoad, &op); err != nil {
+		return Operation{}, ErrOpInvalid
+	}
+
+	if err := op.validate(); err != nil {
+		return Operation{}, err
+	}
+
+	return op, nil
+}
+
+func (op Operation) validate() error {
+	if len(op.Key) == 0 || len(op.Key) > KeySize {
+		return ErrOpInvalid
+	}
+
+	switch op.Op {
+	case OpSet:
+		if len(op.Value) > ValueSize {
+			return ErrOpInvalid
+		}
+	case OpDelete:
+		if len(op.Value) != 0 {
+			return ErrOpInvalid
+		}
+	default:
+		return ErrOpInvalid
+	}
+
+	return nil
+}
+
+func newOperation(op Operation) []byte {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return nil
+	}
+	return data
+}
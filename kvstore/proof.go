@@ -0,0 +1,170 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/number571/go-peer/crypto"
+	"github.com/number571/go-peer/encoding"
+	"github.com/number571/union-bc/kernel"
+)
+
+// Hashes of empty subtrees by depth, from root to leaf.
+var emptyHashes = newEmptyHashes()
+
+// Check proof against state root committed by the chain.
+func VerifyProof(root kernel.Hash, proof Proof) bool {
+	if len(proof.Bitmap) != TreeDepth/8 {
+		return false
+	}
+
+	var (
+		path = treePath(proof.Key)
+		hash = leafHash(proof.Key, proof.Value)
+		pos  = 0
+	)
+
+	for depth := TreeDepth; depth > 0; depth-- {
+		sibling := emptyHashes[depth]
+		if getBit(proof.Bitmap, TreeDepth-depth) {
+			if pos >= len(proof.Path) {
+				return false
+			}
+			sibling = proof.Path[pos]
+			pos++
+		}
+		hash = parentHash(path, depth, hash, sibling)
+	}
+
+	return pos == len(proof.Path) && bytes.Equal(hash, root)
+}
+
+// Sparse binary tree over hashes of keys. Only nodes of
+// non-empty subtrees are stored, so change of one key
+// rewrites one path from leaf to root.
+func (store *KVStoreT) treeRoot() kernel.Hash {
+	return store.getNode(0, nil)
+}
+
+func (store *KVStoreT) treeUpdate(key, value []byte) {
+	var (
+		path = treePath(key)
+		hash = emptyHashes[TreeDepth]
+	)
+
+	if value != nil {
+		hash = leafHash(key, value)
+	}
+
+	for depth := TreeDepth; depth > 0; depth-- {
+		store.setNode(depth, path, hash)
+		sibling := store.getNode(depth, siblingPath(path, depth))
+		hash = parentHash(path, depth, hash, sibling)
+	}
+
+	store.setNode(0, path, hash)
+}
+
+// Siblings from leaf to root, empty ones are marked
+// by zero bits of bitmap and omitted.
+func (store *KVStoreT) treeProof(key []byte) ([]byte, [][]byte) {
+	var (
+		path   = treePath(key)
+		bitmap = make([]byte, TreeDepth/8)
+		result = [][]byte{}
+	)
+
+	for depth := TreeDepth; depth > 0; depth-- {
+		sibling := store.getNode(depth, siblingPath(path, depth))
+		if bytes.Equal(sibling, emptyHashes[depth]) {
+			continue
+		}
+		setBit(bitmap, TreeDepth-depth)
+		result = append(result, sibling)
+	}
+
+	return bitmap, result
+}
+
+func (store *KVStoreT) getNode(depth int, path []byte) []byte {
+	hash := store.ptr.Get(getKeyNode(depth, path))
+	if hash == nil {
+		return emptyHashes[depth]
+	}
+	return hash
+}
+
+func (store *KVStoreT) setNode(depth int, path []byte, hash []byte) {
+	if bytes.Equal(hash, emptyHashes[depth]) {
+		store.ptr.Del(getKeyNode(depth, path))
+		return
+	}
+	store.ptr.Set(getKeyNode(depth, path), hash)
+}
+
+func newEmptyHashes() [][]byte {
+	hashes := make([][]byte, TreeDepth+1)
+	hashes[TreeDepth] = crypto.NewSHA256([]byte{}).Bytes()
+	for depth := TreeDepth - 1; depth >= 0; depth-- {
+		hashes[depth] = nodeHash(hashes[depth+1], hashes[depth+1])
+	}
+	return hashes
+}
+
+// Node at depth is keyed by first depth bits of path.
+func getKeyNode(depth int, path []byte) []byte {
+	prefix := make([]byte, (depth+7)/8)
+	copy(prefix, path)
+	if depth%8 != 0 {
+		prefix[len(prefix)-1] &= 0xFF << (8 - depth%8)
+	}
+	return []byte(fmt.Sprintf(KeyNode, depth, prefix))
+}
+
+func treePath(key []byte) []byte {
+	return crypto.NewSHA256(key).Bytes()
+}
+
+func siblingPath(path []byte, depth int) []byte {
+	sibling := copyBytes(path)
+	sibling[(depth-1)/8] ^= 0x80 >> ((depth - 1) % 8)
+	return sibling
+}
+
+func parentHash(path []byte, depth int, hash, sibling []byte) []byte {
+	if getBit(path, depth-1) {
+		return nodeHash(sibling, hash)
+	}
+	return nodeHash(hash, sibling)
+}
+
+func getBit(data []byte, i int) bool {
+	return data[i/8]&(0x80>>(i%8)) != 0
+}
+
+func setBit(data []byte, i int) {
+	data[i/8] |= 0x80 >> (i % 8)
+}
+
+func leafHash(key, value []byte) []byte {
+	return crypto.NewSHA256(bytes.Join(
+		[][]byte{
+			{leafPrefix},
+			encoding.Uint64ToBytes(uint64(len(key))),
+			key,
+			value,
+		},
+		[]byte{},
+	)).Bytes()
+}
+
+func nodeHash(left, right []byte) []byte {
+	return crypto.NewSHA256(bytes.Join(
+		[][]byte{
+			{nodePrefix},
+			left,
+			right,
+		},
+		[]byte{},
+	)).Bytes()
+}
@@ -0,0 +1,51 @@
+package kvstore
+
+import (
+	"encoding/json"
+
+	"github.com/number571/union-bc/kernel"
+)
+
+var (
+	_ kernel.Schema = &SchemaT{}
+)
+
+// Schema of payloads with type TXTypeKV.
+type SchemaT struct{}
+
+func NewSchema() kernel.Schema {
+	return &SchemaT{}
+}
+
+func (schema *SchemaT) Name() string {
+	return "kv"
+}
+
+func (schema *SchemaT) Decode(payLoad []byte) (interface{}, error) {
+	var op Operation
+	if err := json.Unmarshal(payLoad, &op); err != nil {
+		return nil, ErrOpInvalid
+	}
+	return op, nil
+}
+
+// Operation with key and value as strings.
+func (op Operation) String() string {
+	data, err := json.Marshal(struct {
+		Op    string `json:"op"`
+		Key   string `json:"key"`
+		Value string `json:"value,omitempty"`
+	}{op.Op, string(op.Key), string(op.Value)})
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (schema *SchemaT) Validate(value interface{}) error {
+	op, ok := value.(Operation)
+	if !ok {
+		return ErrOpInvalid
+	}
+	return op.validate()
+}
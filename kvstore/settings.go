@@ -0,0 +1,40 @@
+package kvstore
+
+import (
+	"github.com/number571/union-bc/kernel"
+)
+
+const (
+	TXTypeKV kernel.TXType = 1
+)
+
+const (
+	OpSet    = "set"
+	OpDelete = "delete"
+)
+
+const (
+	KeySize   = 256 // max num bytes in key
+	ValueSize = 512 // max num bytes in value
+	TreeDepth = 256 // num bits in path of key
+
+	// Stores of other version are rebuilt from the chain.
+	StoreVersion = 2
+
+	DBPath = "kvstore.db"
+
+	KeyHeight  = "kvstore.height"
+	KeyVersion = "kvstore.version"
+	KeyValue   = "kvstore.value[%X]"
+	KeyUndo    = "kvstore.undo[%d]"
+	KeyNode    = "kvstore.node[%d][%X]"
+
+	KeyPrefixValue = "kvstore.value["
+	KeyPrefixUndo  = "kvstore.undo["
+	KeyPrefixNode  = "kvstore.node["
+)
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
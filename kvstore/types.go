@@ -0,0 +1,31 @@
+package kvstore
+
+import (
+	"github.com/number571/union-bc/kernel"
+)
+
+// Operation encoded in transaction payload.
+type Operation struct {
+	Op    string `json:"op"`
+	Key   []byte `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+// Inclusion of key and value in state tree of height.
+// Path holds non-empty sibling hashes from leaf to root,
+// bit i of bitmap is set if sibling at level i is in path.
+type Proof struct {
+	Height kernel.Height `json:"height"`
+	Key    []byte        `json:"key"`
+	Value  []byte        `json:"value"`
+	Bitmap []byte        `json:"bitmap"`
+	Path   [][]byte      `json:"path"`
+}
+
+type KVStore interface {
+	kernel.Application
+
+	// Value of key in last committed state with proof of inclusion.
+	Query([]byte) (Proof, bool)
+	Close()
+}
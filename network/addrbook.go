@@ -0,0 +1,384 @@
+package network
+
+import (
+	"encoding/json"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	_ AddrBook = &AddrBookT{}
+)
+
+// Known address of node, times in unix seconds.
+type AddrEntry struct {
+	Address     string `json:"address"`
+	NodeID      string `json:"node_id,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Score       int64  `json:"score"`
+	Failures    uint64 `json:"failures"`
+	LastSeen    int64  `json:"last_seen,omitempty"`
+	LastAttempt int64  `json:"last_attempt,omitempty"`
+	BannedUntil int64  `json:"banned_until,omitempty"`
+}
+
+// Addresses of nodes stored in JSON file. Successful connections
+// raise score, failures lower it, address with score at MinScore
+// is banned for BanTime. Full book evicts entry with lowest score
+// which was never connected. Bans of node ids and IP addresses
+// are kept in the same file, addresses of banned nodes are skipped.
+type AddrBookT struct {
+	mtx     sync.Mutex
+	path    string
+	entries map[string]*AddrEntry
+	bans    map[string]*BanEntry
+}
+
+type addrBookJSON struct {
+	Addresses []*AddrEntry `json:"addresses"`
+	Bans      []*BanEntry  `json:"bans"`
+}
+
+// Load address book from file, missing or corrupted file gives empty book.
+// File with list of addresses only is read as book without bans.
+func NewAddrBook(path string) AddrBook {
+	book := &AddrBookT{
+		path:    path,
+		entries: make(map[string]*AddrEntry),
+		bans:    make(map[string]*BanEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return book
+	}
+
+	bookConv := &addrBookJSON{}
+	if err := json.Unmarshal(data, bookConv); err != nil {
+		if err := json.Unmarshal(data, &bookConv.Addresses); err != nil {
+			return book
+		}
+	}
+
+	for _, entry := range bookConv.Addresses {
+		if entry.Address == "" || len(book.entries) == AddrBookSize {
+			continue
+		}
+		book.entries[entry.Address] = entry
+	}
+
+	for _, entry := range bookConv.Bans {
+		if entry.Key == "" || len(book.bans) == MappSize {
+			continue
+		}
+		book.bans[entry.Key] = entry
+	}
+
+	return book
+}
+
+// Address with other source is told by other node,
+// it starts with ToldScore below addresses of own.
+func (book *AddrBookT) Add(address, source string) bool {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	if _, ok := book.entries[address]; ok {
+		return false
+	}
+
+	score := int64(0)
+	if source != "" && source != address {
+		score = ToldScore
+	}
+
+	if len(book.entries) >= AddrBookSize && !book.evict(score) {
+		return false
+	}
+
+	book.entries[address] = &AddrEntry{
+		Address: address,
+		Source:  source,
+		Score:   score,
+	}
+	return true
+}
+
+func (book *AddrBookT) Remove(address string) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	delete(book.entries, address)
+}
+
+// Connection to address was established with the node.
+func (book *AddrBookT) Good(address, nodeID string) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	entry := book.getEntry(address)
+	if entry == nil {
+		return
+	}
+
+	now := time.Now().Unix()
+	entry.NodeID = nodeID
+	entry.Failures = 0
+	entry.LastSeen = now
+	entry.LastAttempt = now
+	if entry.Score < MaxScore {
+		entry.Score++
+	}
+}
+
+// Connection to address failed.
+func (book *AddrBookT) Bad(address string) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	entry := book.getEntry(address)
+	if entry == nil {
+		return
+	}
+
+	entry.Failures++
+	entry.LastAttempt = time.Now().Unix()
+	book.penalize(entry, 1)
+}
+
+// Ban of node id or IP address.
+func (book *AddrBookT) Ban(ban BanEntry) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	if len(book.bans) >= MappSize {
+		book.pruneBans(time.Now().Unix())
+	}
+	book.bans[ban.Key] = &ban
+}
+
+// Lift ban of node id or IP address.
+func (book *AddrBookT) Unban(key string) bool {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	_, ok := book.bans[key]
+	delete(book.bans, key)
+	return ok
+}
+
+// Active bans ordered by end time.
+func (book *AddrBookT) Bans() []BanEntry {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	result := []BanEntry{}
+	for _, ban := range book.activeBans() {
+		result = append(result, *ban)
+	}
+	return result
+}
+
+// Key is node id, IP address or address of node. Address is
+// banned by its failures or by ban of its node id or IP address.
+func (book *AddrBookT) IsBanned(key string) bool {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	return book.isBanned(key, time.Now().Unix())
+}
+
+func (book *AddrBookT) Entry(address string) (AddrEntry, bool) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	entry, ok := book.entries[address]
+	if !ok {
+		return AddrEntry{}, false
+	}
+	return *entry, true
+}
+
+// All entries including banned, ordered by score.
+func (book *AddrBookT) Entries() []AddrEntry {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	result := []AddrEntry{}
+	for _, entry := range book.sorted(false) {
+		result = append(result, *entry)
+	}
+	return result
+}
+
+// Not banned addresses with the best score.
+func (book *AddrBookT) Select(num int) []string {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	result := []string{}
+	for _, entry := range book.sorted(true) {
+		if len(result) == num {
+			break
+		}
+		result = append(result, entry.Address)
+	}
+	return result
+}
+
+// Random not banned addresses for peer exchange.
+func (book *AddrBookT) Sample(num int) []string {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	entries := book.sorted(true)
+	rand.Shuffle(len(entries), func(i, j int) {
+		entries[i], entries[j] = entries[j], entries[i]
+	})
+
+	result := []string{}
+	for _, entry := range entries {
+		if len(result) == num {
+			break
+		}
+		result = append(result, entry.Address)
+	}
+	return result
+}
+
+// Write book to temporary file and rename it over the old one.
+func (book *AddrBookT) Save() error {
+	book.mtx.Lock()
+	bookConv := &addrBookJSON{
+		Addresses: book.sorted(false),
+		Bans:      book.activeBans(),
+	}
+	data, err := json.MarshalIndent(bookConv, "", "\t")
+	book.mtx.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	tmpPath := book.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, book.path)
+}
+
+func (book *AddrBookT) getEntry(address string) *AddrEntry {
+	entry, ok := book.entries[address]
+	if !ok {
+		return nil
+	}
+	return entry
+}
+
+func (book *AddrBookT) isBanned(key string, now int64) bool {
+	if book.hasBan(key, now) {
+		return true
+	}
+
+	if host, _, err := net.SplitHostPort(key); err == nil {
+		if ip := bannableIP(host); ip != "" && book.hasBan(ip, now) {
+			return true
+		}
+	}
+
+	entry, ok := book.entries[key]
+	if !ok {
+		return false
+	}
+	if entry.NodeID != "" && book.hasBan(entry.NodeID, now) {
+		return true
+	}
+	return entry.BannedUntil > now
+}
+
+func (book *AddrBookT) hasBan(key string, now int64) bool {
+	ban, ok := book.bans[key]
+	return ok && ban.Until > now
+}
+
+func (book *AddrBookT) activeBans() []*BanEntry {
+	book.pruneBans(time.Now().Unix())
+
+	bans := make([]*BanEntry, 0, len(book.bans))
+	for _, ban := range book.bans {
+		bans = append(bans, ban)
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].Until != bans[j].Until {
+			return bans[i].Until < bans[j].Until
+		}
+		return bans[i].Key < bans[j].Key
+	})
+
+	return bans
+}
+
+func (book *AddrBookT) pruneBans(now int64) {
+	for key, ban := range book.bans {
+		if ban.Until <= now {
+			delete(book.bans, key)
+		}
+	}
+}
+
+func (book *AddrBookT) penalize(entry *AddrEntry, points int64) {
+	entry.Score -= points
+	if entry.Score > MinScore {
+		return
+	}
+	entry.Score = MinScore
+	entry.BannedUntil = time.Now().Add(BanTime * time.Second).Unix()
+}
+
+// Entry is evicted only by address with the same or higher score.
+func (book *AddrBookT) evict(score int64) bool {
+	entries := book.sorted(false)
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Score > score {
+			return false
+		}
+		if entry.LastSeen != 0 {
+			continue
+		}
+		delete(book.entries, entry.Address)
+		return true
+	}
+	return false
+}
+
+func (book *AddrBookT) sorted(skipBanned bool) []*AddrEntry {
+	var (
+		now     = time.Now().Unix()
+		entries = make([]*AddrEntry, 0, len(book.entries))
+	)
+
+	for _, entry := range book.entries {
+		if skipBanned && book.isBanned(entry.Address, now) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		if entries[i].LastSeen != entries[j].LastSeen {
+			return entries[i].LastSeen > entries[j].LastSeen
+		}
+		return entries[i].Address < entries[j].Address
+	})
+
+	return entries
+}
@@ -0,0 +1,160 @@
+package network
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	_ BanList = &BanListT{}
+)
+
+const (
+	BanKindNode = "node"
+	BanKindIP   = "ip"
+)
+
+// Ban of node identity or IP address, time in unix seconds.
+type BanEntry struct {
+	Key    string `json:"key"`
+	Kind   string `json:"kind"`
+	Reason string `json:"reason"`
+	Until  int64  `json:"until"`
+}
+
+// Misbehavior scores of node identities. Score decays by one point
+// every ScoreDecay seconds, identity with score at BanScore is banned
+// for BanTime together with its IP address. Loopback addresses are
+// not banned by IP, local nodes share them. Bans are kept in address
+// book, so addresses of banned nodes are skipped by peer manager.
+type BanListT struct {
+	mtx    sync.Mutex
+	book   AddrBook
+	scores map[string]*scoreEntry
+}
+
+type scoreEntry struct {
+	score   int64
+	updated int64
+}
+
+func NewBanList(book AddrBook) BanList {
+	return &BanListT{
+		book:   book,
+		scores: make(map[string]*scoreEntry),
+	}
+}
+
+// Add points to score of connection, true is returned if it was banned.
+func (bl *BanListT) Misbehave(conn Conn, points int64, reason string) bool {
+	bl.mtx.Lock()
+	defer bl.mtx.Unlock()
+
+	var (
+		nodeID = conn.Hello().NodeID
+		now    = time.Now().Unix()
+	)
+
+	if len(bl.scores) >= MappSize {
+		bl.prune(now)
+	}
+
+	entry, ok := bl.scores[nodeID]
+	if !ok {
+		entry = &scoreEntry{updated: now}
+		bl.scores[nodeID] = entry
+	}
+
+	entry.score = decayScore(entry, now) + points
+	entry.updated = now
+	if entry.score < BanScore {
+		return false
+	}
+
+	delete(bl.scores, nodeID)
+
+	until := now + BanTime
+	bl.book.Ban(BanEntry{
+		Key:    nodeID,
+		Kind:   BanKindNode,
+		Reason: reason,
+		Until:  until,
+	})
+
+	if ip := connIP(conn); ip != "" {
+		bl.book.Ban(BanEntry{
+			Key:    ip,
+			Kind:   BanKindIP,
+			Reason: reason,
+			Until:  until,
+		})
+	}
+
+	return true
+}
+
+// Current score of node identity.
+func (bl *BanListT) Score(nodeID string) int64 {
+	bl.mtx.Lock()
+	defer bl.mtx.Unlock()
+
+	entry, ok := bl.scores[nodeID]
+	if !ok {
+		return 0
+	}
+	return decayScore(entry, time.Now().Unix())
+}
+
+// Key is node id or IP address.
+func (bl *BanListT) IsBanned(key string) bool {
+	return bl.book.IsBanned(key)
+}
+
+// Lift ban of node id or IP address.
+func (bl *BanListT) Unban(key string) bool {
+	bl.mtx.Lock()
+	delete(bl.scores, key)
+	bl.mtx.Unlock()
+
+	return bl.book.Unban(key)
+}
+
+// Active bans ordered by end time.
+func (bl *BanListT) Bans() []BanEntry {
+	return bl.book.Bans()
+}
+
+// Delete fully decayed scores.
+func (bl *BanListT) prune(now int64) {
+	for key, entry := range bl.scores {
+		if decayScore(entry, now) == 0 {
+			delete(bl.scores, key)
+		}
+	}
+}
+
+func decayScore(entry *scoreEntry, now int64) int64 {
+	score := entry.score - (now-entry.updated)/ScoreDecay
+	if score < 0 {
+		return 0
+	}
+	return score
+}
+
+// IP address of remote side if it is not loopback.
+func connIP(conn Conn) string {
+	host, _, err := net.SplitHostPort(conn.Address())
+	if err != nil {
+		return ""
+	}
+	return bannableIP(host)
+}
+
+func bannableIP(host string) string {
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() {
+		return ""
+	}
+	return ip.String()
+}
@@ -1,10 +1,14 @@
 package network
 
 import (
-	"bytes"
+	"bufio"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/number571/go-peer/crypto"
@@ -14,118 +18,320 @@ var (
 	_ Conn = &ConnT{}
 )
 
+var (
+	ErrClosed         = errors.New("network: connection closed")
+	ErrTimeout        = errors.New("network: request timeout")
+	ErrMessageSize    = errors.New("network: message size exceeded")
+	ErrMessageInvalid = errors.New("network: message is invalid")
+)
+
+// Messages are read by one goroutine of connection. Replies are
+// routed to waiting requests by id, other messages go to Read.
+// Messages are written by one goroutine from bounded queue.
 type ConnT struct {
-	nonce string
-	ptr   net.Conn
+	nonce  string
+	ptr    *secureConn
+	reader *bufio.Reader
+	hello  Hello
+	once   sync.Once
+	done   chan struct{}
+	err    error
+
+	seq     uint64
+	inbox   chan Message
+	pendMtx sync.Mutex
+	pending map[uint64]chan Message
+
+	sent         uint64
+	dropped      uint64
+	inboxDropped uint64
+	maxDepth     int64
+	policy       QueuePolicy
+	queue        chan *sendItem
 }
 
-func NewConn(address string) Conn {
-	conn, err := net.Dial("tcp", address)
+// Connect to node as client, private key is identity of client.
+func NewConn(priv crypto.PrivKey, address string) Conn {
+	ctx, cancel := context.WithTimeout(context.Background(), TimeSize*time.Second)
+	defer cancel()
+
+	conn, err := NewConnContext(ctx, priv, address)
 	if err != nil {
 		return nil
 	}
+	return conn
+}
+
+// Connect to node as client, context limits dial and handshake.
+func NewConnContext(ctx context.Context, priv crypto.PrivKey, address string) (Conn, error) {
+	conn, err := dialSecure(ctx, priv, address)
+	if err != nil {
+		return nil, err
+	}
 
-	conn.Write([]byte{IsClient})
-	return &ConnT{crypto.RandString(16), conn}
+	hello, err := exchangeHello(ctx, conn, Hello{
+		Version: Version,
+		Role:    IsClient,
+		NodeID:  priv.PubKey().Address(),
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return newConn(conn, hello, QueueDrop), nil
 }
 
-func (conn *ConnT) Request(msg Message) Message {
-	conn.Write(msg)
+func dialSecure(ctx context.Context, priv crypto.PrivKey, address string) (*secureConn, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	sconn, err := newSecureConn(ctx, conn, priv, roleInitiator)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
 
-	ch := make(chan Message)
-	go readMessage(conn, ch)
+	return sconn, nil
+}
+
+func newConn(conn *secureConn, hello Hello, policy QueuePolicy) *ConnT {
+	iconn := &ConnT{
+		nonce:   crypto.RandString(16),
+		ptr:     conn,
+		reader:  bufio.NewReaderSize(conn, ReadBufferSize),
+		hello:   hello,
+		done:    make(chan struct{}),
+		inbox:   make(chan Message, InboxSize),
+		pending: make(map[uint64]chan Message),
+		policy:  policy,
+		queue:   make(chan *sendItem, QueueSize),
+	}
+	go iconn.readLoop()
+	go iconn.writeLoop()
+	return iconn
+}
+
+// Send message with new id and wait reply within TimeSize.
+func (conn *ConnT) Request(msg Message) (Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), TimeSize*time.Second)
+	defer cancel()
+
+	rmsg, err := conn.RequestContext(ctx, msg)
+	if err == context.DeadlineExceeded {
+		return nil, ErrTimeout
+	}
+	return rmsg, err
+}
+
+// Send message with new id and wait reply until context is done.
+// Requests of one connection may run concurrently.
+func (conn *ConnT) RequestContext(ctx context.Context, msg Message) (Message, error) {
+	var (
+		id = atomic.AddUint64(&conn.seq, 1)
+		ch = make(chan Message, 1)
+	)
+
+	conn.pendMtx.Lock()
+	conn.pending[id] = ch
+	conn.pendMtx.Unlock()
+
+	defer func() {
+		conn.pendMtx.Lock()
+		delete(conn.pending, id)
+		conn.pendMtx.Unlock()
+	}()
+
+	if err := conn.WriteContext(ctx, withID(msg, id)); err != nil {
+		return nil, err
+	}
 
 	select {
 	case rmsg := <-ch:
-		return rmsg
-	case <-time.After(TimeSize * time.Second):
-		fmt.Println(777)
-		return nil
+		return rmsg, nil
+	case <-conn.done:
+		return nil, conn.closeErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
+// Identity of remote side authenticated by handshake.
+func (conn *ConnT) PubKey() crypto.PubKey {
+	return conn.ptr.PubKey()
+}
+
+// Hello of remote side with negotiated version and capabilities.
+func (conn *ConnT) Hello() Hello {
+	return conn.hello
+}
+
+// Connection was dialed by local side.
+func (conn *ConnT) Outbound() bool {
+	return conn.ptr.initiator
+}
+
+// Remote address of connection.
+func (conn *ConnT) Address() string {
+	return conn.ptr.RemoteAddr().String()
+}
+
 func (conn *ConnT) Close() error {
+	conn.closeWith(ErrClosed)
 	return conn.ptr.Close()
 }
 
-func (conn *ConnT) Write(msg Message) {
-	conn.ptr.Write(msg.Bytes())
+// Closed when connection is closed.
+func (conn *ConnT) Done() <-chan struct{} {
+	return conn.done
 }
 
-func (conn *ConnT) Read() Message {
-	ch := make(chan Message)
-	go readMessage(conn, ch)
-	return <-ch
+// Write message within TimeSize, slow peer gets error instead of
+// blocking the caller.
+func (conn *ConnT) Write(msg Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), TimeSize*time.Second)
+	defer cancel()
+
+	return conn.WriteContext(ctx, msg)
 }
 
-func readMessage(conn *ConnT, ch chan Message) {
-	const (
-		SizeUint64 = 8 // bytes
-	)
+// Next message which is not a reply, messages over full inbox are
+// dropped and counted in Stats. Error is returned when connection
+// is closed, with reason of closing.
+func (conn *ConnT) Read() (Message, error) {
+	return conn.ReadContext(context.Background())
+}
 
-	var (
-		pack   []byte
-		size   = uint64(0)
-		msg    = new(MessageT)
-		buflen = make([]byte, SizeUint64)
-	)
+func (conn *ConnT) ReadContext(ctx context.Context) (Message, error) {
+	select {
+	case msg := <-conn.inbox:
+		return msg, nil
+	case <-conn.done:
+		return nil, conn.closeErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
 
-	length, err := conn.ptr.Read(buflen)
-	if err != nil {
-		// fmt.Println(111)
-		ch <- nil
-		return
+// Wait for place in queue and for write of message. Waiting
+// is interrupted by cancel or deadline of context, message
+// not written yet is skipped then.
+func (conn *ConnT) WriteContext(ctx context.Context, msg Message) error {
+	select {
+	case <-conn.done:
+		return conn.closeErr()
+	default:
 	}
 
-	if length != SizeUint64 {
-		fmt.Println(222)
-		ch <- nil
-		return
+	data := msg.Bytes()
+	if data == nil {
+		return ErrMessageInvalid
 	}
 
-	mustLen := PackageT(buflen).BytesToSize()
-	if mustLen > PackSize {
-		fmt.Println(333)
-		ch <- nil
-		return
+	item := &sendItem{
+		ctx:    ctx,
+		data:   data,
+		result: make(chan error, 1),
 	}
 
-	for {
-		buffer := make([]byte, mustLen-size)
+	select {
+	case conn.queue <- item:
+		conn.trackDepth()
+	case <-conn.done:
+		return conn.closeErr()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-item.result:
+		return err
+	case <-conn.done:
+		return conn.closeErr()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-		length, err = conn.ptr.Read(buffer)
+func (conn *ConnT) readLoop() {
+	for {
+		msg, err := conn.readMessage()
 		if err != nil {
-			fmt.Println(444)
-			ch <- nil
+			conn.closeWith(err)
+			conn.ptr.Close()
 			return
 		}
 
-		pack = bytes.Join(
-			[][]byte{
-				pack,
-				buffer[:length],
-			},
-			[]byte{},
-		)
-
-		size += uint64(length)
-		if size == mustLen {
-			break
+		// Message over full inbox is dropped and counted,
+		// so replies are routed while Read is behind.
+		if msg.Reply() == 0 {
+			select {
+			case conn.inbox <- msg:
+			default:
+				atomic.AddUint64(&conn.inboxDropped, 1)
+			}
+			continue
+		}
+
+		conn.pendMtx.Lock()
+		ch, ok := conn.pending[msg.Reply()]
+		delete(conn.pending, msg.Reply())
+		conn.pendMtx.Unlock()
+
+		// Reply after timeout of request is dropped.
+		if ok {
+			ch <- msg
 		}
 	}
+}
 
-	err = json.Unmarshal(pack, msg)
-	if err != nil {
-		fmt.Println(555)
-		ch <- nil
-		return
+// Frame is size in 8 bytes and message in JSON. Waiting for the next
+// frame is not limited, started frame must be read within TimeSize.
+func (conn *ConnT) readMessage() (Message, error) {
+	sizeBytes := make([]byte, 8)
+	if _, err := io.ReadFull(conn.reader, sizeBytes); err != nil {
+		return nil, err
+	}
+
+	size := PackageT(sizeBytes).BytesToSize()
+	if size > PackSize {
+		return nil, ErrMessageSize
+	}
+
+	conn.ptr.SetReadDeadline(time.Now().Add(TimeSize * time.Second))
+	defer conn.ptr.SetReadDeadline(time.Time{})
+
+	pack := make([]byte, size)
+	if _, err := io.ReadFull(conn.reader, pack); err != nil {
+		return nil, err
+	}
+
+	msg := new(MessageT)
+	if err := json.Unmarshal(pack, msg); err != nil {
+		return nil, ErrMessageInvalid
 	}
 
 	if msg.Network() != NetworkName {
-		fmt.Println(666)
-		ch <- nil
-		return
+		return nil, ErrMessageInvalid
 	}
 
-	ch <- msg
+	return msg, nil
+}
+
+func (conn *ConnT) closeWith(err error) {
+	conn.once.Do(func() {
+		conn.err = err
+		close(conn.done)
+	})
+}
+
+// Reason of closing, valid after done is closed.
+func (conn *ConnT) closeErr() error {
+	if conn.err == io.EOF {
+		return ErrClosed
+	}
+	return conn.err
 }
@@ -0,0 +1,124 @@
+package network
+
+import (
+	"container/list"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Hashes of seen messages with source connection. Entry expires after
+// SeenTime since it was seen last time, full cache evicts least
+// recently seen entry.
+type seenCache struct {
+	mtx   sync.Mutex
+	order *list.List
+	items map[string]*list.Element
+}
+
+type seenEntry struct {
+	hash string
+	from string
+	time time.Time
+}
+
+func newSeenCache() *seenCache {
+	return &seenCache{
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Mark message as seen from connection,
+// false is returned for duplicate.
+func (cache *seenCache) Add(hash, from string) bool {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+
+	now := time.Now()
+	cache.expire(now)
+
+	if elem, ok := cache.items[hash]; ok {
+		elem.Value.(*seenEntry).time = now
+		cache.order.MoveToFront(elem)
+		return false
+	}
+
+	if uint(cache.order.Len()) >= MappSize {
+		cache.remove(cache.order.Back())
+	}
+
+	cache.items[hash] = cache.order.PushFront(&seenEntry{
+		hash: hash,
+		from: from,
+		time: now,
+	})
+	return true
+}
+
+// Connection from which message was received first.
+func (cache *seenCache) From(hash string) string {
+	cache.mtx.Lock()
+	defer cache.mtx.Unlock()
+
+	elem, ok := cache.items[hash]
+	if !ok {
+		return ""
+	}
+	return elem.Value.(*seenEntry).from
+}
+
+func (cache *seenCache) expire(now time.Time) {
+	for {
+		elem := cache.order.Back()
+		if elem == nil || now.Sub(elem.Value.(*seenEntry).time) < SeenTime*time.Second {
+			return
+		}
+		cache.remove(elem)
+	}
+}
+
+func (cache *seenCache) remove(elem *list.Element) {
+	cache.order.Remove(elem)
+	delete(cache.items, elem.Value.(*seenEntry).hash)
+}
+
+// Random peers subscribed to topic of message, except source of
+// message and peers with GossipPending messages in send queue.
+func (node *NodeT) gossipPeers(msg Message, from string) []*ConnT {
+	node.mainMtx.Lock()
+	fanout := node.fanout
+	peers := []*ConnT{}
+	for _, conn := range node.connections {
+		if conn.nonce == from || !conn.subscribed(msg.Head()) {
+			continue
+		}
+		if len(conn.queue) >= GossipPending {
+			continue
+		}
+		peers = append(peers, conn)
+	}
+	node.mainMtx.Unlock()
+
+	rand.Shuffle(len(peers), func(i, j int) {
+		peers[i], peers[j] = peers[j], peers[i]
+	})
+
+	if fanout > 0 && len(peers) > fanout {
+		peers = peers[:fanout]
+	}
+	return peers
+}
+
+// Peer without topics in hello receives all messages.
+func (conn *ConnT) subscribed(topic MsgType) bool {
+	if len(conn.hello.Topics) == 0 {
+		return true
+	}
+	for _, t := range conn.hello.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
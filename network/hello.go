@@ -0,0 +1,121 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	ErrHelloInvalid = errors.New("network: hello is invalid")
+	ErrVersion      = errors.New("network: protocol version is not supported")
+	ErrChainID      = errors.New("network: chain id mismatch")
+	ErrNodeID       = errors.New("network: node id mismatch")
+	ErrSelfConn     = errors.New("network: connection to itself")
+)
+
+// First message of both sides after transport handshake.
+// Client may not set chain id, then chain of node is not checked.
+type Hello struct {
+	Version      uint32    `json:"version"`
+	Role         byte      `json:"role"`
+	ChainID      []byte    `json:"chain_id,omitempty"`
+	Height       uint64    `json:"height"`
+	NodeID       string    `json:"node_id"`
+	Address      string    `json:"address,omitempty"`
+	Capabilities []string  `json:"capabilities,omitempty"`
+	Topics       []MsgType `json:"topics,omitempty"`
+}
+
+// Exchange hello messages and check the peer. Returned hello of peer
+// holds negotiated version and capabilities supported by both sides.
+func exchangeHello(ctx context.Context, conn *secureConn, local Hello) (Hello, error) {
+	stop := watchContext(ctx, conn.SetDeadline)
+	peer, err := sendHello(conn, local)
+	stop()
+
+	if ctx.Err() != nil {
+		return Hello{}, ctx.Err()
+	}
+	return peer, err
+}
+
+func sendHello(conn *secureConn, local Hello) (Hello, error) {
+	data, err := json.Marshal(local)
+	if err != nil {
+		return Hello{}, err
+	}
+
+	if err := writeFrame(conn, data); err != nil {
+		return Hello{}, err
+	}
+
+	data, err = readFrame(conn, HandshakeSize)
+	if err != nil {
+		return Hello{}, err
+	}
+
+	peer := Hello{}
+	if err := json.Unmarshal(data, &peer); err != nil {
+		return Hello{}, ErrHelloInvalid
+	}
+
+	if err := checkHello(conn, local, peer); err != nil {
+		return Hello{}, err
+	}
+
+	if peer.Version > local.Version {
+		peer.Version = local.Version
+	}
+	peer.Capabilities = intersect(local.Capabilities, peer.Capabilities)
+
+	return peer, nil
+}
+
+func checkHello(conn *secureConn, local, peer Hello) error {
+	if peer.Role != IsNode && peer.Role != IsClient {
+		return ErrHelloInvalid
+	}
+
+	if peer.Version < MinVersion {
+		return ErrVersion
+	}
+
+	if len(peer.Topics) > TopicSize {
+		return ErrHelloInvalid
+	}
+
+	// Identity is bound to key authenticated by transport.
+	if peer.NodeID != conn.PubKey().Address() {
+		return ErrNodeID
+	}
+
+	if peer.Role == IsNode && local.Role == IsNode && peer.NodeID == local.NodeID {
+		return ErrSelfConn
+	}
+
+	// Only client may skip chain id of its side.
+	if len(peer.ChainID) == 0 && peer.Role == IsClient {
+		return nil
+	}
+
+	if len(local.ChainID) != 0 && !bytes.Equal(local.ChainID, peer.ChainID) {
+		return ErrChainID
+	}
+
+	return nil
+}
+
+func intersect(local, peer []string) []string {
+	result := []string{}
+	for _, capability := range peer {
+		for _, own := range local {
+			if capability == own {
+				result = append(result, capability)
+				break
+			}
+		}
+	}
+	return result
+}
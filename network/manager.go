@@ -0,0 +1,252 @@
+package network
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	_ PeerManager = &PeerManagerT{}
+)
+
+// Keeps target number of outbound connections. Dropped and failed
+// addresses are dialed again after exponential backoff, connection
+// that lived shortly does not reset backoff of address.
+type PeerManagerT struct {
+	mtx sync.Mutex
+
+	node    Node
+	book    AddrBook
+	target  int
+	connect ConnectFunc
+	wake    chan struct{}
+
+	persistent map[string]bool
+	peers      map[string]*peerState
+}
+
+type peerState struct {
+	conn     Conn
+	attempts uint
+	since    time.Time
+	nextTry  time.Time
+}
+
+// Candidates of connection are persistent addresses
+// and then addresses of book with the best score.
+func NewPeerManager(node Node, book AddrBook, target int) PeerManager {
+	return &PeerManagerT{
+		node:       node,
+		book:       book,
+		target:     target,
+		wake:       make(chan struct{}, 1),
+		persistent: make(map[string]bool),
+		peers:      make(map[string]*peerState),
+	}
+}
+
+// Address is dialed again after any number of failures and bans.
+func (pm *PeerManagerT) AddPersistent(address string) PeerManager {
+	pm.book.Add(address, "")
+
+	pm.mtx.Lock()
+	pm.persistent[address] = true
+	pm.mtx.Unlock()
+
+	pm.notify()
+	return pm
+}
+
+// Function is called for every new outbound connection.
+func (pm *PeerManagerT) OnConnect(connect ConnectFunc) PeerManager {
+	pm.mtx.Lock()
+	defer pm.mtx.Unlock()
+
+	pm.connect = connect
+	return pm
+}
+
+// Maintain connections until context is done or node is shut down.
+func (pm *PeerManagerT) Run(ctx context.Context) error {
+	ticker := time.NewTicker(ManageInterval * time.Second)
+	defer ticker.Stop()
+
+	for {
+		pm.fill(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-pm.node.Done():
+			return nil
+		case <-ticker.C:
+		case <-pm.wake:
+		}
+	}
+}
+
+func (pm *PeerManagerT) fill(ctx context.Context) {
+	for _, address := range pm.candidates() {
+		if ctx.Err() != nil || pm.numOutbound() >= pm.target {
+			return
+		}
+
+		switch pm.dial(ctx, address) {
+		case ErrNodeClosed, ErrConnLimit:
+			return
+		}
+	}
+}
+
+func (pm *PeerManagerT) dial(ctx context.Context, address string) error {
+	dctx, cancel := context.WithTimeout(ctx, TimeSize*time.Second)
+	defer cancel()
+
+	conn, err := pm.node.ConnectContext(dctx, address)
+
+	pm.mtx.Lock()
+	var (
+		state   = pm.getState(address)
+		connect = pm.connect
+		now     = time.Now()
+	)
+
+	switch err {
+	case nil:
+		state.conn = conn
+		state.since = now
+	case ErrSelfConn:
+		delete(pm.persistent, address)
+		delete(pm.peers, address)
+	case ErrDuplicate:
+		state.nextTry = now.Add(BackoffMax * time.Second)
+	case ErrNodeClosed, ErrConnLimit:
+	default:
+		state.attempts++
+		state.nextTry = now.Add(backoff(state.attempts))
+	}
+	pm.mtx.Unlock()
+
+	switch err {
+	case nil:
+		pm.book.Good(address, conn.Hello().NodeID)
+		go pm.watch(address, conn)
+		if connect != nil {
+			connect(conn)
+		}
+	case ErrSelfConn:
+		pm.book.Remove(address)
+	case ErrDuplicate, ErrNodeClosed, ErrConnLimit:
+	default:
+		pm.book.Bad(address)
+	}
+
+	return err
+}
+
+func (pm *PeerManagerT) watch(address string, conn Conn) {
+	<-conn.Done()
+
+	pm.mtx.Lock()
+	state := pm.getState(address)
+	if state.conn == conn {
+		if time.Since(state.since) < BackoffMax*time.Second {
+			state.attempts++
+		} else {
+			state.attempts = 0
+		}
+		state.conn = nil
+		state.nextTry = time.Now().Add(backoff(state.attempts))
+	}
+	pm.mtx.Unlock()
+
+	pm.notify()
+}
+
+// Addresses ready to dial, not connected to the same node identity.
+func (pm *PeerManagerT) candidates() []string {
+	connected := make(map[string]bool)
+	for _, conn := range pm.node.Connections() {
+		connected[conn.Hello().NodeID] = true
+	}
+
+	pm.mtx.Lock()
+	defer pm.mtx.Unlock()
+
+	persistent := []string{}
+	for address := range pm.persistent {
+		persistent = append(persistent, address)
+	}
+	sort.Strings(persistent)
+
+	var (
+		now    = time.Now()
+		seen   = make(map[string]bool)
+		result = []string{}
+	)
+
+	for _, address := range append(persistent, pm.book.Select(CandidateSize)...) {
+		if seen[address] {
+			continue
+		}
+		seen[address] = true
+
+		if state, ok := pm.peers[address]; ok {
+			if state.conn != nil || now.Before(state.nextTry) {
+				continue
+			}
+		}
+
+		if !pm.persistent[address] && pm.book.IsBanned(address) {
+			continue
+		}
+
+		if entry, ok := pm.book.Entry(address); ok && connected[entry.NodeID] {
+			continue
+		}
+
+		result = append(result, address)
+	}
+
+	return result
+}
+
+func (pm *PeerManagerT) numOutbound() int {
+	count := 0
+	for _, conn := range pm.node.Connections() {
+		if conn.Outbound() {
+			count++
+		}
+	}
+	return count
+}
+
+func (pm *PeerManagerT) getState(address string) *peerState {
+	state, ok := pm.peers[address]
+	if !ok {
+		state = &peerState{}
+		pm.peers[address] = state
+	}
+	return state
+}
+
+func (pm *PeerManagerT) notify() {
+	select {
+	case pm.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Delay doubles with every attempt from BackoffMin up to BackoffMax.
+func backoff(attempts uint) time.Duration {
+	delay := time.Duration(BackoffMax) * time.Second
+	if attempts < 16 {
+		delay = time.Duration(BackoffMin<<attempts) * time.Second
+	}
+	if delay > BackoffMax*time.Second {
+		delay = BackoffMax * time.Second
+	}
+	return delay
+}
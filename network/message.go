@@ -12,6 +12,8 @@ var (
 )
 
 type MessageT struct {
+	IDT      uint64  `json:"id,omitempty"`
+	ReplyT   uint64  `json:"reply,omitempty"`
 	HeadT    MsgType `json:"head"`
 	BodyT    []byte  `json:"body"`
 	NonceT   []byte  `json:"nonce"`
@@ -28,6 +30,35 @@ func NewMessage(head MsgType, body []byte) Message {
 	}
 }
 
+// Create reply routed to the caller of request.
+func NewReply(req Message, head MsgType, body []byte) Message {
+	msg := NewMessage(head, body).(*MessageT)
+	msg.ReplyT = req.ID()
+	return msg
+}
+
+// Copy of message with request id.
+func withID(msg Message, id uint64) Message {
+	return &MessageT{
+		IDT:      id,
+		ReplyT:   msg.Reply(),
+		HeadT:    msg.Head(),
+		BodyT:    msg.Body(),
+		NonceT:   msg.Nonce(),
+		NetworkT: msg.Network(),
+	}
+}
+
+// Id of request, zero if reply is not expected.
+func (msg *MessageT) ID() uint64 {
+	return msg.IDT
+}
+
+// Id of request answered by message, zero if it is not a reply.
+func (msg *MessageT) Reply() uint64 {
+	return msg.ReplyT
+}
+
 func (msg *MessageT) Head() MsgType {
 	return msg.HeadT
 }
@@ -1,8 +1,12 @@
 package network
 
 import (
+	"context"
+	"errors"
 	"net"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/number571/go-peer/crypto"
 )
@@ -11,21 +15,51 @@ var (
 	_ Node = &NodeT{}
 )
 
+var (
+	ErrNodeClosed = errors.New("network: node is closed")
+	ErrConnLimit  = errors.New("network: connection limit reached")
+	ErrDuplicate  = errors.New("network: node is already connected")
+	ErrBanned     = errors.New("network: node is banned")
+)
+
 // Basic structure for network use.
 type NodeT struct {
 	mainMtx  sync.Mutex
 	routeMtx sync.Mutex
 
-	mapping      map[string]bool
-	connections  map[string]Conn
+	priv  crypto.PrivKey
+	hello HelloFunc
+	bans  BanList
+
+	// Canceled by shutdown, stops listeners and reading of connections.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	seen   *seenCache
+	fanout int
+	topics map[MsgType]bool
+	policy QueuePolicy
+
+	connections  map[string]*ConnT
+	active       map[string]*ConnT
 	handleRoutes map[MsgType]HandleFunc
 }
 
 // Create client by private key as identification.
-func NewNode() Node {
+func NewNode(priv crypto.PrivKey) Node {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &NodeT{
-		mapping:      make(map[string]bool),
-		connections:  make(map[string]Conn),
+		priv:         priv,
+		bans:         NewBanList(NewAddrBook("")),
+		ctx:          ctx,
+		cancel:       cancel,
+		seen:         newSeenCache(),
+		fanout:       GossipFanout,
+		policy:       QueueDrop,
+		topics:       make(map[MsgType]bool),
+		connections:  make(map[string]*ConnT),
+		active:       make(map[string]*ConnT),
 		handleRoutes: make(map[MsgType]HandleFunc),
 	}
 }
@@ -34,54 +68,208 @@ func (node *NodeT) Mutex() *sync.Mutex {
 	return &node.routeMtx
 }
 
+// Send message to fanout of random peers subscribed to its topic.
+// Message received from peer is not sent back to it, busy peers
+// are skipped, so cost does not grow with number of connections.
 func (node *NodeT) Broadcast(msg Message) {
-	node.setMapping(msg.Hash())
+	hash := msg.Hash()
+	node.seen.Add(hash, "")
 
-	for _, conn := range node.Connections() {
-		go conn.Write(msg)
+	for _, conn := range node.gossipPeers(msg, node.seen.From(hash)) {
+		conn.Send(msg)
 	}
 }
 
 // Turn on listener by address.
 // Client handle function need be not null.
 func (node *NodeT) Listen(address string) error {
-	listen, err := net.Listen("tcp", address)
+	return node.ListenContext(context.Background(), address)
+}
+
+// Listener is stopped by cancel of context or by shutdown of node.
+func (node *NodeT) ListenContext(ctx context.Context, address string) error {
+	if node.ctx.Err() != nil {
+		return ErrNodeClosed
+	}
+
+	config := &net.ListenConfig{}
+	listen, err := config.Listen(ctx, "tcp", address)
 	if err != nil {
 		return err
 	}
 	defer listen.Close()
 
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-node.ctx.Done():
+		case <-stop:
+		}
+		listen.Close()
+	}()
+
 	for {
 		conn, err := listen.Accept()
 		if err != nil {
-			break
+			if ctx.Err() != nil || node.ctx.Err() != nil {
+				return nil
+			}
+			return err
 		}
 
-		if node.hasMaxConnSize() {
+		if node.hasMaxConnSize() || node.isBannedAddr(conn.RemoteAddr().String()) {
 			conn.Close()
 			continue
 		}
 
-		var (
-			iconn = &ConnT{crypto.RandString(16), conn}
-			whoIs = make([]byte, 1)
-		)
-		conn.Read(whoIs)
-
-		switch whoIs[0] {
-		case IsNode:
-			node.setConnection(iconn)
-		case IsClient:
-			// do nothing
-		default:
-			conn.Close()
-			continue
+		node.wg.Add(1)
+		go node.accept(conn)
+	}
+}
+
+// Handshake is done out of listener loop,
+// slow peer does not block other connections.
+func (node *NodeT) accept(conn net.Conn) {
+	defer node.wg.Done()
+
+	ctx, cancel := context.WithTimeout(node.ctx, TimeSize*time.Second)
+	defer cancel()
+
+	sconn, err := newSecureConn(ctx, conn, node.priv, roleResponder)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	if node.bans.IsBanned(sconn.PubKey().Address()) {
+		conn.Close()
+		return
+	}
+
+	hello, err := exchangeHello(ctx, sconn, node.localHello())
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	iconn := newConn(sconn, hello, node.queuePolicy())
+	if hello.Role == IsNode {
+		if err := node.setConnection(iconn); err != nil {
+			iconn.Close()
+			return
 		}
+	}
+
+	node.handleConn(iconn)
+}
 
-		go node.handleConn(iconn)
+// Stop listeners and reading of connections, wait for running
+// handlers until context is done, then close all connections.
+func (node *NodeT) Shutdown(ctx context.Context) error {
+	node.cancel()
+
+	drained := make(chan struct{})
+	go func() {
+		node.wg.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
 	}
 
-	return nil
+	node.mainMtx.Lock()
+	for _, conn := range node.active {
+		conn.Close()
+	}
+	node.mainMtx.Unlock()
+
+	return err
+}
+
+func (node *NodeT) Close() error {
+	return node.Shutdown(context.Background())
+}
+
+// Closed when shutdown of node is started.
+func (node *NodeT) Done() <-chan struct{} {
+	return node.ctx.Done()
+}
+
+// Scores and bans of peers.
+func (node *NodeT) BanList() BanList {
+	return node.bans
+}
+
+// Add points to misbehavior score of peer, banned peer
+// is disconnected. Connections with key of node are trusted.
+func (node *NodeT) Misbehave(conn Conn, points int64, reason string) {
+	if conn.PubKey().Equal(node.PubKey()) {
+		return
+	}
+	if node.bans.Misbehave(conn, points, reason) {
+		conn.Close()
+	}
+}
+
+// Identity of node in handshakes.
+func (node *NodeT) PubKey() crypto.PubKey {
+	return node.priv.PubKey()
+}
+
+// Set source of chain id, height, listening address
+// and capabilities sent in hello messages.
+func (node *NodeT) SetHello(hello HelloFunc) Node {
+	node.mainMtx.Lock()
+	defer node.mainMtx.Unlock()
+
+	node.hello = hello
+	return node
+}
+
+// Number of peers receiving broadcast message,
+// zero sends it to all subscribed peers.
+func (node *NodeT) SetFanout(fanout int) Node {
+	node.mainMtx.Lock()
+	defer node.mainMtx.Unlock()
+
+	node.fanout = fanout
+	return node
+}
+
+// Policy of new connections for messages sent to full queue.
+func (node *NodeT) SetQueuePolicy(policy QueuePolicy) Node {
+	node.mainMtx.Lock()
+	defer node.mainMtx.Unlock()
+
+	node.policy = policy
+	return node
+}
+
+// Bans of peers are kept in address book and saved with it,
+// by default they are kept only in memory.
+func (node *NodeT) SetAddrBook(book AddrBook) Node {
+	node.mainMtx.Lock()
+	defer node.mainMtx.Unlock()
+
+	node.bans = NewBanList(book)
+	return node
+}
+
+// Topic is announced in hello, peers broadcast to this node only
+// subscribed topics. Node without subscriptions receives all topics.
+func (node *NodeT) Subscribe(topic MsgType) Node {
+	node.mainMtx.Lock()
+	defer node.mainMtx.Unlock()
+
+	node.topics[topic] = true
+	return node
 }
 
 // Add function to mapping for route use.
@@ -91,33 +279,46 @@ func (node *NodeT) Handle(tmsg MsgType, handle HandleFunc) Node {
 }
 
 func (node *NodeT) handleConn(conn *ConnT) {
+	node.setActive(conn)
 	defer func() {
 		node.delConnection(conn)
 	}()
 
-	counter := 0
+	var (
+		counter = 0
+		unknown = 0
+	)
 
 	for counter != RetrySize {
-		msg := conn.Read()
-		if msg == nil {
-			counter++
-			continue
+		msg, err := conn.ReadContext(node.ctx)
+		if err != nil {
+			switch err {
+			case ErrMessageSize, ErrMessageInvalid, ErrFrameSize, ErrFrameAuth:
+				node.Misbehave(conn, PenaltyProtocol, err.Error())
+			}
+			return
 		}
 
-		hash := msg.Hash()
-		if node.inMapping(hash) {
+		if !node.seen.Add(msg.Hash(), conn.nonce) {
 			counter++
 			continue
 		}
-		node.setMapping(hash)
 
 		ok := node.handleFunc(conn, msg)
 		if !ok {
+			node.Misbehave(conn, PenaltyUnknown, "unknown message")
 			counter++
+			unknown++
 			continue
 		}
 
 		counter = 0
+		unknown = 0
+	}
+
+	// Duplicates of gossip from several peers are not penalized.
+	if unknown != 0 {
+		node.Misbehave(conn, PenaltySpam, "repeated unknown messages")
 	}
 }
 
@@ -150,29 +351,110 @@ func (node *NodeT) Connections() []Conn {
 // Connect to node by address.
 // Client handle function need be not null.
 func (node *NodeT) Connect(address string) Conn {
-	if node.hasMaxConnSize() {
+	ctx, cancel := context.WithTimeout(context.Background(), TimeSize*time.Second)
+	defer cancel()
+
+	conn, err := node.ConnectContext(ctx, address)
+	if err != nil {
 		return nil
 	}
+	return conn
+}
+
+// Context limits dial and handshake, not the connection.
+func (node *NodeT) ConnectContext(ctx context.Context, address string) (Conn, error) {
+	if node.ctx.Err() != nil {
+		return nil, ErrNodeClosed
+	}
+
+	if node.hasMaxConnSize() {
+		return nil, ErrConnLimit
+	}
+
+	if node.isBannedAddr(address) {
+		return nil, ErrBanned
+	}
 
-	conn, err := net.Dial("tcp", address)
+	conn, err := dialSecure(ctx, node.priv, address)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
-	conn.Write([]byte{IsNode})
+	if node.bans.IsBanned(conn.PubKey().Address()) {
+		conn.Close()
+		return nil, ErrBanned
+	}
 
-	iconn := &ConnT{crypto.RandString(16), conn}
+	hello, err := exchangeHello(ctx, conn, node.localHello())
+	if err == nil && hello.Role != IsNode {
+		err = ErrHelloInvalid
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
 
-	node.setConnection(iconn)
-	go node.handleConn(iconn)
+	iconn := newConn(conn, hello, node.queuePolicy())
+	if err := node.setConnection(iconn); err != nil {
+		iconn.Close()
+		return nil, err
+	}
+
+	node.wg.Add(1)
+	go func() {
+		defer node.wg.Done()
+		node.handleConn(iconn)
+	}()
 
-	return iconn
+	return iconn, nil
 }
 
 func (node *NodeT) Disconnect(conn Conn) {
 	node.delConnection(conn.(*ConnT))
 }
 
+func (node *NodeT) localHello() Hello {
+	node.mainMtx.Lock()
+	helloFunc := node.hello
+	topics := []MsgType{}
+	for topic := range node.topics {
+		topics = append(topics, topic)
+	}
+	node.mainMtx.Unlock()
+
+	hello := Hello{}
+	if helloFunc != nil {
+		hello = helloFunc()
+	}
+
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i] < topics[j]
+	})
+	hello.Topics = topics
+
+	hello.Version = Version
+	hello.Role = IsNode
+	hello.NodeID = node.PubKey().Address()
+
+	return hello
+}
+
+func (node *NodeT) queuePolicy() QueuePolicy {
+	node.mainMtx.Lock()
+	defer node.mainMtx.Unlock()
+
+	return node.policy
+}
+
+func (node *NodeT) isBannedAddr(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	ip := bannableIP(host)
+	return ip != "" && node.bans.IsBanned(ip)
+}
+
 func (node *NodeT) setFunction(tmsg MsgType, handle HandleFunc) {
 	node.mainMtx.Lock()
 	defer node.mainMtx.Unlock()
@@ -195,11 +477,39 @@ func (node *NodeT) hasMaxConnSize() bool {
 	return len(node.connections) > ConnSize
 }
 
-func (node *NodeT) setConnection(conn *ConnT) {
+// One connection is kept for node identity. New connection in the
+// same direction as old one is rejected. For crossed dials both sides
+// keep connection dialed by node with lower id.
+func (node *NodeT) setConnection(conn *ConnT) error {
 	node.mainMtx.Lock()
 	defer node.mainMtx.Unlock()
 
+	nodeID := conn.Hello().NodeID
+	for nonce, old := range node.connections {
+		if old.Hello().NodeID != nodeID {
+			continue
+		}
+		if old.Outbound() == conn.Outbound() || !node.preferConn(conn) {
+			return ErrDuplicate
+		}
+		delete(node.connections, nonce)
+		old.Close()
+		break
+	}
+
 	node.connections[conn.nonce] = conn
+	return nil
+}
+
+func (node *NodeT) preferConn(conn *ConnT) bool {
+	var (
+		localID  = node.PubKey().Address()
+		remoteID = conn.Hello().NodeID
+	)
+	if conn.Outbound() {
+		return localID < remoteID
+	}
+	return remoteID < localID
 }
 
 func (node *NodeT) delConnection(conn *ConnT) {
@@ -207,27 +517,14 @@ func (node *NodeT) delConnection(conn *ConnT) {
 	defer node.mainMtx.Unlock()
 
 	delete(node.connections, conn.nonce)
+	delete(node.active, conn.nonce)
 	conn.Close()
 }
 
-func (node *NodeT) inMapping(hash string) bool {
+// Connections of nodes and clients served by node.
+func (node *NodeT) setActive(conn *ConnT) {
 	node.mainMtx.Lock()
 	defer node.mainMtx.Unlock()
 
-	_, ok := node.mapping[hash]
-	return ok
-}
-
-func (node *NodeT) setMapping(hash string) {
-	node.mainMtx.Lock()
-	defer node.mainMtx.Unlock()
-
-	if uint(len(node.mapping)) > MappSize {
-		for k := range node.mapping {
-			delete(node.mapping, k)
-			break
-		}
-	}
-
-	node.mapping[hash] = true
+	node.active[conn.nonce] = conn
 }
@@ -0,0 +1,133 @@
+package network
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrQueueFull = errors.New("network: send queue is full")
+)
+
+// Action for message sent to connection with full queue.
+type QueuePolicy byte
+
+const (
+	QueueDrop       QueuePolicy = 1 // message is dropped
+	QueueDisconnect QueuePolicy = 2 // connection is closed
+)
+
+// Send queue and inbox of connection, counters since connection
+// was opened. Inbox dropped counts messages over full inbox.
+type QueueStats struct {
+	Depth        int    `json:"depth"`
+	Capacity     int    `json:"capacity"`
+	MaxDepth     int    `json:"max_depth"`
+	Sent         uint64 `json:"sent"`
+	Dropped      uint64 `json:"dropped"`
+	InboxDropped uint64 `json:"inbox_dropped"`
+}
+
+// Message waiting in queue. Result is set for
+// writes which wait, context for cancelable ones.
+type sendItem struct {
+	ctx    context.Context
+	data   []byte
+	result chan error
+}
+
+// Queue message without waiting for write. When queue is full
+// message is dropped or connection is closed by policy.
+func (conn *ConnT) Send(msg Message) error {
+	data := msg.Bytes()
+	if data == nil {
+		return ErrMessageInvalid
+	}
+
+	select {
+	case <-conn.done:
+		return conn.closeErr()
+	default:
+	}
+
+	select {
+	case conn.queue <- &sendItem{data: data}:
+		conn.trackDepth()
+		return nil
+	default:
+	}
+
+	atomic.AddUint64(&conn.dropped, 1)
+	if conn.policy == QueueDisconnect {
+		conn.closeWith(ErrQueueFull)
+		conn.ptr.Close()
+	}
+	return ErrQueueFull
+}
+
+func (conn *ConnT) Stats() QueueStats {
+	return QueueStats{
+		Depth:        len(conn.queue),
+		Capacity:     cap(conn.queue),
+		MaxDepth:     int(atomic.LoadInt64(&conn.maxDepth)),
+		Sent:         atomic.LoadUint64(&conn.sent),
+		Dropped:      atomic.LoadUint64(&conn.dropped),
+		InboxDropped: atomic.LoadUint64(&conn.inboxDropped),
+	}
+}
+
+// Only writer of connection, frames are written in order of queue.
+// Peer which does not read within TimeSize is disconnected.
+func (conn *ConnT) writeLoop() {
+	for {
+		var item *sendItem
+
+		select {
+		case <-conn.done:
+			return
+		case item = <-conn.queue:
+		}
+
+		if item.ctx != nil && item.ctx.Err() != nil {
+			item.result <- item.ctx.Err()
+			continue
+		}
+
+		conn.ptr.SetWriteDeadline(writeDeadline(item))
+		_, err := conn.ptr.Write(item.data)
+
+		if item.result != nil {
+			item.result <- err
+		}
+
+		if err != nil {
+			conn.closeWith(err)
+			conn.ptr.Close()
+			return
+		}
+
+		atomic.AddUint64(&conn.sent, 1)
+	}
+}
+
+// Deadline of context or TimeSize from now.
+func writeDeadline(item *sendItem) time.Time {
+	if item.ctx != nil {
+		if deadline, ok := item.ctx.Deadline(); ok {
+			return deadline
+		}
+	}
+	return time.Now().Add(TimeSize * time.Second)
+}
+
+func (conn *ConnT) trackDepth() {
+	depth := int64(len(conn.queue))
+	for {
+		max := atomic.LoadInt64(&conn.maxDepth)
+		if depth <= max || atomic.CompareAndSwapInt64(&conn.maxDepth, max, depth) {
+			return
+		}
+	}
+}
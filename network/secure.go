@@ -0,0 +1,358 @@
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/number571/go-peer/crypto"
+	"github.com/number571/go-peer/encoding"
+)
+
+var (
+	ErrHandshake = errors.New("network: handshake failed")
+	ErrFrameSize = errors.New("network: frame size exceeded")
+	ErrFrameAuth = errors.New("network: frame authentication failed")
+)
+
+const (
+	roleInitiator byte = 1
+	roleResponder byte = 2
+)
+
+// Session over TCP connection. Ephemeral X25519 keys of both sides
+// are signed by their long-term keys, frames are sealed by AES-GCM
+// with separate key and counter nonce in each direction.
+type secureConn struct {
+	net.Conn
+
+	peer      crypto.PubKey
+	initiator bool
+
+	sendMtx sync.Mutex
+	send    cipher.AEAD
+	sendSeq uint64
+
+	recvMtx sync.Mutex
+	recv    cipher.AEAD
+	recvSeq uint64
+	buffer  []byte
+}
+
+// Ephemeral and long-term keys of side.
+type keyMessage struct {
+	Eph    []byte `json:"eph"`
+	PubKey []byte `json:"pub_key"`
+}
+
+type authMessage struct {
+	Sign []byte `json:"sign"`
+}
+
+func newSecureConn(ctx context.Context, conn net.Conn, priv crypto.PrivKey, role byte) (*secureConn, error) {
+	stop := watchContext(ctx, conn.SetDeadline)
+	sconn, err := handshake(conn, priv, role)
+	stop()
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return sconn, err
+}
+
+// Each side signs transcript with both long-term keys, so the
+// peer which signed is the peer which holds the session keys.
+func handshake(conn net.Conn, priv crypto.PrivKey, role byte) (*secureConn, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := json.Marshal(keyMessage{
+		Eph:    eph.PublicKey().Bytes(),
+		PubKey: priv.PubKey().Bytes(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFrame(conn, keys); err != nil {
+		return nil, err
+	}
+
+	peerKeysBytes, err := readFrame(conn, HandshakeSize)
+	if err != nil {
+		return nil, err
+	}
+
+	peerKeys := keyMessage{}
+	if err := json.Unmarshal(peerKeysBytes, &peerKeys); err != nil {
+		return nil, ErrHandshake
+	}
+
+	peerEph, err := ecdh.X25519().NewPublicKey(peerKeys.Eph)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+
+	if _, err := x509.ParsePKCS1PublicKey(peerKeys.PubKey); err != nil {
+		return nil, ErrHandshake
+	}
+
+	var (
+		pub        = priv.PubKey().Bytes()
+		peer       = crypto.LoadPubKey(peerKeys.PubKey)
+		peerRole   = roleResponder
+		transcript []byte
+		initPub    []byte
+		respPub    []byte
+	)
+
+	if role == roleInitiator {
+		transcript = newTranscript(eph.PublicKey().Bytes(), peerKeys.Eph)
+		initPub, respPub = pub, peerKeys.PubKey
+	} else {
+		peerRole = roleInitiator
+		transcript = newTranscript(peerKeys.Eph, eph.PublicKey().Bytes())
+		initPub, respPub = peerKeys.PubKey, pub
+	}
+
+	auth, err := json.Marshal(authMessage{
+		Sign: priv.Sign(authHash(transcript, role, pub, peerKeys.PubKey)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeFrame(conn, auth); err != nil {
+		return nil, err
+	}
+
+	peerAuthBytes, err := readFrame(conn, HandshakeSize)
+	if err != nil {
+		return nil, err
+	}
+
+	peerAuth := authMessage{}
+	if err := json.Unmarshal(peerAuthBytes, &peerAuth); err != nil {
+		return nil, ErrHandshake
+	}
+
+	if !peer.Verify(authHash(transcript, peerRole, peerKeys.PubKey, pub), peerAuth.Sign) {
+		return nil, ErrHandshake
+	}
+
+	shared, err := eph.ECDH(peerEph)
+	if err != nil {
+		return nil, ErrHandshake
+	}
+
+	initiator, err := newAEAD(shared, transcript, roleInitiator, initPub, respPub)
+	if err != nil {
+		return nil, err
+	}
+
+	responder, err := newAEAD(shared, transcript, roleResponder, initPub, respPub)
+	if err != nil {
+		return nil, err
+	}
+
+	sconn := &secureConn{
+		Conn:      conn,
+		peer:      peer,
+		initiator: role == roleInitiator,
+		send:      initiator,
+		recv:      responder,
+	}
+
+	if role == roleResponder {
+		sconn.send, sconn.recv = responder, initiator
+	}
+
+	return sconn, nil
+}
+
+// Identity of remote side.
+func (sconn *secureConn) PubKey() crypto.PubKey {
+	return sconn.peer
+}
+
+// Every call is sealed as one frame.
+func (sconn *secureConn) Write(data []byte) (int, error) {
+	sconn.sendMtx.Lock()
+	defer sconn.sendMtx.Unlock()
+
+	if len(data) > FrameSize {
+		return 0, ErrFrameSize
+	}
+
+	size := encoding.Uint64ToBytes(uint64(len(data) + sconn.send.Overhead()))
+	sealed := sconn.send.Seal(nil, newNonce(sconn.sendSeq), data, size)
+	sconn.sendSeq++
+
+	_, err := sconn.Conn.Write(bytes.Join([][]byte{size, sealed}, []byte{}))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (sconn *secureConn) Read(data []byte) (int, error) {
+	sconn.recvMtx.Lock()
+	defer sconn.recvMtx.Unlock()
+
+	if len(sconn.buffer) == 0 {
+		size := make([]byte, 8)
+		if _, err := io.ReadFull(sconn.Conn, size); err != nil {
+			return 0, err
+		}
+
+		length := encoding.BytesToUint64(size)
+		if length > uint64(FrameSize+sconn.recv.Overhead()) {
+			return 0, ErrFrameSize
+		}
+
+		sealed := make([]byte, length)
+		if _, err := io.ReadFull(sconn.Conn, sealed); err != nil {
+			return 0, err
+		}
+
+		opened, err := sconn.recv.Open(nil, newNonce(sconn.recvSeq), sealed, size)
+		if err != nil {
+			return 0, ErrFrameAuth
+		}
+		sconn.recvSeq++
+		sconn.buffer = opened
+	}
+
+	n := copy(data, sconn.buffer)
+	sconn.buffer = sconn.buffer[n:]
+	return n, nil
+}
+
+// Deadline of connection follows the context, cancel of context
+// interrupts blocked io. Returned function stops watching.
+func watchContext(ctx context.Context, setDeadline func(time.Time) error) func() {
+	if deadline, ok := ctx.Deadline(); ok {
+		setDeadline(deadline)
+	}
+
+	var (
+		stop   = make(chan struct{})
+		exited = make(chan struct{})
+	)
+
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			setDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-exited
+		setDeadline(time.Time{})
+	}
+}
+
+func newTranscript(initiator, responder []byte) []byte {
+	return crypto.NewSHA256(bytes.Join(
+		[][]byte{
+			[]byte(NetworkName),
+			initiator,
+			responder,
+		},
+		[]byte{},
+	)).Bytes()
+}
+
+// Signed by side of role with its key, for peer with peer key.
+func authHash(transcript []byte, role byte, pubKey, peerKey []byte) []byte {
+	return crypto.NewSHA256(bytes.Join(
+		[][]byte{
+			transcript,
+			{role},
+			crypto.NewSHA256(pubKey).Bytes(),
+			crypto.NewSHA256(peerKey).Bytes(),
+		},
+		[]byte{},
+	)).Bytes()
+}
+
+// Key of direction by HKDF-SHA256: extract with transcript as salt,
+// expand one block with role and long-term keys of both sides as info.
+func newAEAD(shared, transcript []byte, role byte, initPub, respPub []byte) (cipher.AEAD, error) {
+	info := bytes.Join(
+		[][]byte{
+			{role},
+			crypto.NewSHA256(initPub).Bytes(),
+			crypto.NewSHA256(respPub).Bytes(),
+			{0x01},
+		},
+		[]byte{},
+	)
+
+	prk := crypto.NewHMAC256(shared, transcript).Bytes()
+	key := crypto.NewHMAC256(info, prk).Bytes()
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func newNonce(seq uint64) []byte {
+	return bytes.Join(
+		[][]byte{
+			make([]byte, 4),
+			encoding.Uint64ToBytes(seq),
+		},
+		[]byte{},
+	)
+}
+
+func writeFrame(conn net.Conn, data []byte) error {
+	_, err := conn.Write(bytes.Join(
+		[][]byte{
+			encoding.Uint64ToBytes(uint64(len(data))),
+			data,
+		},
+		[]byte{},
+	))
+	return err
+}
+
+func readFrame(conn net.Conn, limit uint64) ([]byte, error) {
+	size := make([]byte, 8)
+	if _, err := io.ReadFull(conn, size); err != nil {
+		return nil, err
+	}
+
+	length := encoding.BytesToUint64(size)
+	if length > limit {
+		return nil, ErrFrameSize
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
@@ -1,15 +1,53 @@
 package network
 
 const (
-	MappSize  = 2048      // hashes
+	MappSize  = 2048      // hashes of seen messages
 	ConnSize  = 512       // max num connections
 	RetrySize = 32        // num retry send
 	TimeSize  = 5         // seconds
 	PackSize  = (2 << 20) // 2MiB
+	InboxSize = 64        // messages waiting Read, others are dropped
+	QueueSize = 256       // messages waiting write
+
+	HandshakeSize  = (4 << 10)    // 4KiB
+	FrameSize      = PackSize + 8 // message with size
+	ReadBufferSize = (64 << 10)   // 64KiB
+)
+
+const (
+	AddrBookSize = 1024 // max num addresses in book
+	MaxScore     = 100  // score of address with good connections
+	MinScore     = -10  // score of address to ban it
+	ToldScore    = -1   // score of address told by other node
+	BanTime      = 3600 // seconds
+)
+
+const (
+	SeenTime      = 120 // seconds
+	GossipFanout  = 4   // peers receiving broadcast message
+	GossipPending = 8   // queued messages to skip peer in gossip
+	TopicSize     = 64  // topics in hello
+)
+
+const (
+	BanScore        = 100 // misbehavior score to ban node
+	ScoreDecay      = 60  // seconds to forgive one point
+	PenaltyProtocol = 50  // invalid frame or message
+	PenaltyUnknown  = 5   // message without handler
+	PenaltySpam     = 20  // RetrySize messages with unknown among them
+)
+
+const (
+	CandidateSize  = 32  // addresses of book checked for connection
+	ManageInterval = 1   // seconds
+	BackoffMin     = 1   // seconds
+	BackoffMax     = 300 // seconds
 )
 
 const (
 	NetworkName = "union-network"
+	Version     = 1 // protocol version
+	MinVersion  = 1 // min supported version of peer
 )
 
 const (
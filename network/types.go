@@ -1,13 +1,21 @@
 package network
 
 import (
+	"context"
 	"sync"
+
+	"github.com/number571/go-peer/crypto"
 )
 
 type MsgType uint32
 type HandleFunc func(Node, Conn, Message)
+type HelloFunc func() Hello
+type ConnectFunc func(Conn)
 
 type Message interface {
+	ID() uint64
+	Reply() uint64
+
 	Head() MsgType
 	Body() []byte
 
@@ -27,21 +35,76 @@ type Package interface {
 }
 
 type Conn interface {
-	Request(Message) Message
+	Request(Message) (Message, error)
+	RequestContext(context.Context, Message) (Message, error)
+	PubKey() crypto.PubKey
+	Hello() Hello
+	Outbound() bool
+	Address() string
+	Done() <-chan struct{}
 	Close() error
 
-	Write(Message)
-	Read() Message
+	Send(Message) error
+	Stats() QueueStats
+	Write(Message) error
+	WriteContext(context.Context, Message) error
+	Read() (Message, error)
+	ReadContext(context.Context) (Message, error)
+}
+
+type AddrBook interface {
+	Add(string, string) bool
+	Remove(string)
+
+	Good(string, string)
+	Bad(string)
+	Ban(BanEntry)
+	Unban(string) bool
+	Bans() []BanEntry
+	IsBanned(string) bool
+
+	Entry(string) (AddrEntry, bool)
+	Entries() []AddrEntry
+	Select(int) []string
+	Sample(int) []string
+	Save() error
+}
+
+type PeerManager interface {
+	AddPersistent(string) PeerManager
+	OnConnect(ConnectFunc) PeerManager
+	Run(context.Context) error
+}
+
+type BanList interface {
+	Misbehave(Conn, int64, string) bool
+	Score(string) int64
+	IsBanned(string) bool
+	Unban(string) bool
+	Bans() []BanEntry
 }
 
 type Node interface {
 	Mutex() *sync.Mutex
+	PubKey() crypto.PubKey
 
 	Broadcast(Message)
 	Listen(string) error
+	ListenContext(context.Context, string) error
+	Shutdown(context.Context) error
+	Close() error
+	Done() <-chan struct{}
 	Handle(MsgType, HandleFunc) Node
+	SetHello(HelloFunc) Node
+	SetFanout(int) Node
+	SetQueuePolicy(QueuePolicy) Node
+	SetAddrBook(AddrBook) Node
+	Subscribe(MsgType) Node
+	BanList() BanList
+	Misbehave(Conn, int64, string)
 
 	Connect(string) Conn
+	ConnectContext(context.Context, string) (Conn, error)
 	Disconnect(Conn)
 	Connections() []Conn
 }